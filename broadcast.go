@@ -0,0 +1,121 @@
+package stf
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// BackpressurePolicy decide what to do when a subscriber queue is full
+type BackpressurePolicy int
+
+const (
+	DropOldest BackpressurePolicy = iota // discard the oldest queued frame
+	DropNewest                           // discard the incoming frame
+	LatestOnly                           // queue size is always 1, keep the newest
+)
+
+// Subscription is a single subscriber of Broadcaster
+// C will be closed after unsubscribe
+type Subscription struct {
+	C         <-chan *Frame
+	c         chan *Frame
+	policy    BackpressurePolicy
+	delivered uint64
+	dropped   uint64
+}
+
+// Delivered return how many frames have been put into queue
+func (s *Subscription) Delivered() uint64 {
+	return atomic.LoadUint64(&s.delivered)
+}
+
+// Dropped return how many frames have been discarded because of a full queue
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) push(f *Frame) {
+	select {
+	case s.c <- f:
+		atomic.AddUint64(&s.delivered, 1)
+		return
+	default:
+	}
+	if s.policy == DropNewest {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	// make room for the new one
+	select {
+	case <-s.c:
+		atomic.AddUint64(&s.dropped, 1)
+	default:
+	}
+	select {
+	case s.c <- f:
+		atomic.AddUint64(&s.delivered, 1)
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Broadcaster fan out frames to many subscribers, every subscriber have its own queue
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		subs: make(map[*Subscription]bool),
+	}
+}
+
+// Subscribe create a subscriber with queue size, size is ignored when policy is LatestOnly
+func (b *Broadcaster) Subscribe(policy BackpressurePolicy, size int) *Subscription {
+	if size <= 0 || policy == LatestOnly {
+		size = 1
+	}
+	c := make(chan *Frame, size)
+	sub := &Subscription{C: c, c: c, policy: policy}
+	b.mu.Lock()
+	b.subs[sub] = true
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe will also close channel
+func (b *Broadcaster) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// UnsubscribeAll close all subscribers, Broadcaster can still be used after that
+func (b *Broadcaster) UnsubscribeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Publish never blocks, slow subscribers lose frames according to their policy
+func (b *Broadcaster) Publish(f *Frame) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		sub.push(f)
+	}
+}
+
+// Len return number of subscribers
+func (b *Broadcaster) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
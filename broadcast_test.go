@@ -0,0 +1,39 @@
+package stf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroadcasterPolicy(t *testing.T) {
+	b := NewBroadcaster()
+	oldest := b.Subscribe(DropOldest, 2)
+	newest := b.Subscribe(DropNewest, 2)
+	latest := b.Subscribe(LatestOnly, 10)
+	for i := 1; i <= 3; i++ {
+		b.Publish(&Frame{Seq: uint64(i)})
+	}
+
+	assert.Equal(t, uint64(2), (<-oldest.C).Seq)
+	assert.Equal(t, uint64(3), (<-oldest.C).Seq)
+	assert.Equal(t, uint64(1), oldest.Dropped())
+
+	assert.Equal(t, uint64(1), (<-newest.C).Seq)
+	assert.Equal(t, uint64(2), (<-newest.C).Seq)
+	assert.Equal(t, uint64(1), newest.Dropped())
+
+	assert.Equal(t, uint64(3), (<-latest.C).Seq)
+	assert.Equal(t, uint64(2), latest.Dropped())
+	assert.Equal(t, uint64(3), latest.Delivered())
+
+	b.Unsubscribe(oldest)
+	_, ok := <-oldest.C
+	assert.False(t, ok)
+	assert.Equal(t, 2, b.Len())
+
+	b.UnsubscribeAll()
+	_, ok = <-latest.C
+	assert.False(t, ok)
+	assert.Equal(t, 0, b.Len())
+}
@@ -0,0 +1,142 @@
+package stf
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	adb "github.com/openatx/go-adb"
+	"github.com/pkg/errors"
+)
+
+const defaultDumpsysRotationInterval = 500 * time.Millisecond
+
+var (
+	surfaceOrientationRe = regexp.MustCompile(`SurfaceOrientation:\s*(\d+)`)
+	displayRotationRe    = regexp.MustCompile(`\bm(?:Current)?Rotation=(ROTATION_)?(\d+)`)
+)
+
+// parseDumpsysRotation return rotation in degrees from output of "dumpsys input" or "dumpsys window displays"
+//
+//	SurfaceOrientation: 1           dumpsys input
+//	mCurrentRotation=1              dumpsys window displays, before android 10
+//	mCurrentRotation=ROTATION_90    dumpsys window displays, android 10+
+func parseDumpsysRotation(out string) (int, error) {
+	var value string
+	var degrees bool
+	if m := surfaceOrientationRe.FindStringSubmatch(out); m != nil {
+		value = m[1]
+	} else if m := displayRotationRe.FindStringSubmatch(out); m != nil {
+		value, degrees = m[2], m[1] != ""
+	} else {
+		return 0, errors.New("no rotation found in dumpsys output")
+	}
+	n, _ := strconv.Atoi(value)
+	if !degrees && n >= 0 && n <= 3 {
+		n *= 90
+	}
+	switch n {
+	case 0, 90, 180, 270:
+		return n, nil
+	}
+	return 0, errors.New("invalid rotation: " + value)
+}
+
+// DumpsysRotation is a RotationWatcher which poll dumpsys, no apk is needed
+type DumpsysRotation struct {
+	// Interval between two polls
+	Interval time.Duration
+	// MaxRetry is how many polls can fail continuously before give up
+	MaxRetry int
+
+	args  []string
+	quitC chan bool
+	run   func(name string, args ...string) (string, error)
+
+	*adb.Device
+	*rotationHub
+	errorMixin
+	safeMixin
+}
+
+func NewDumpsysRotation(device *adb.Device) *DumpsysRotation {
+	s := &DumpsysRotation{
+		Interval:    defaultDumpsysRotationInterval,
+		MaxRetry:    defaultRotationMaxRetry,
+		Device:      device,
+		rotationHub: newRotationHub(),
+	}
+	s.run = func(name string, args ...string) (string, error) {
+		return AdbCheckOutput(s.Device, name, args...)
+	}
+	return s
+}
+
+// Start choose the first dumpsys command which contains rotation
+func (s *DumpsysRotation) Start() error {
+	return s.safeDo(_ACTION_START, func() error {
+		var err error
+		for _, args := range [][]string{{"input"}, {"window", "displays"}} {
+			var r int
+			if r, err = s.poll(args); err == nil {
+				s.resetError()
+				s.watchState(s.Wait)
+				s.args = args
+				s.quitC = make(chan bool)
+				s.pub(r)
+				go s.keepPolling()
+				return nil
+			}
+		}
+		return errors.Wrap(err, "dumpsys rotation")
+	})
+}
+
+func (s *DumpsysRotation) Stop() error {
+	return s.safeDo(_ACTION_STOP, func() error {
+		close(s.quitC)
+		return s.Wait()
+	})
+}
+
+func (s *DumpsysRotation) poll(args []string) (int, error) {
+	out, err := s.run("dumpsys", args...)
+	if err != nil {
+		return 0, err
+	}
+	return parseDumpsysRotation(out)
+}
+
+// keepPolling publish only when rotation changed
+func (s *DumpsysRotation) keepPolling() {
+	var err error
+	defer func() {
+		s.close()
+		s.doneError(err)
+	}()
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	leftRetry := s.MaxRetry
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.quitC:
+			err = nil
+			return
+		}
+		var r int
+		if r, err = s.poll(s.args); err != nil {
+			if leftRetry -= 1; leftRetry <= 0 {
+				err = errors.Wrap(err, "dumpsys rotation")
+				return
+			}
+			s.restarting(err)
+			continue
+		}
+		leftRetry = s.MaxRetry
+		s.recovered()
+		if last, er := s.Rotation(); er != nil || last != r {
+			s.pub(r)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package stf
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDumpsysRotation(t *testing.T) {
+	for out, r := range map[string]int{
+		"    Viewport: displayId=0\n      SurfaceOrientation: 1\n      Translation: 0": 90,
+		"  mDisplayId=0 init=1080x1920 420dpi cur=1920x1080\n  mCurrentRotation=3":     270,
+		"  mCurrentRotation=ROTATION_180 mLastOrientation=-1":                          180,
+		"  mRotation=ROTATION_0 mUserRotation=ROTATION_90":                             0,
+	} {
+		v, err := parseDumpsysRotation(out)
+		assert.NoError(t, err, out)
+		assert.Equal(t, r, v, out)
+	}
+	_, err := parseDumpsysRotation("Can't find service: input")
+	assert.Error(t, err)
+	_, err = parseDumpsysRotation("SurfaceOrientation: 7")
+	assert.Error(t, err)
+}
+
+func TestDumpsysRotation(t *testing.T) {
+	var mu sync.Mutex
+	outputs := []string{"mCurrentRotation=0", "mCurrentRotation=0", "mCurrentRotation=1"}
+	var calls []string
+	s := NewDumpsysRotation(nil)
+	s.Interval = time.Millisecond
+	s.run = func(name string, args ...string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, strings.Join(args, " "))
+		if args[0] == "input" {
+			return "", errors.New("input not available")
+		}
+		if len(outputs) == 0 {
+			return "mCurrentRotation=1", nil
+		}
+		out := outputs[0]
+		outputs = outputs[1:]
+		return out, nil
+	}
+	subC := s.Subscribe()
+	assert.NoError(t, s.Start())
+	assert.Equal(t, 0, <-subC)
+	assert.Equal(t, 90, <-subC)
+	select {
+	case v := <-subC:
+		t.Fatalf("unchanged rotation published: %d", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.NoError(t, s.Stop())
+	_, ok := <-subC
+	assert.False(t, ok)
+	mu.Lock()
+	assert.Equal(t, "window displays", calls[len(calls)-1])
+	mu.Unlock()
+}
+
+func TestDumpsysRotationStopAfterFailure(t *testing.T) {
+	s := NewDumpsysRotation(nil)
+	s.Interval = time.Millisecond
+	polled := make(chan bool, 1)
+	var mu sync.Mutex
+	calls := 0
+	s.run = func(name string, args ...string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if calls++; calls == 1 {
+			return "SurfaceOrientation: 0", nil
+		}
+		select {
+		case polled <- true:
+		default:
+		}
+		return "", errors.New("dumpsys timeout")
+	}
+	assert.NoError(t, s.Start())
+	<-polled
+	assert.NoError(t, s.Stop())
+	assert.NoError(t, s.Wait())
+}
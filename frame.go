@@ -0,0 +1,206 @@
+package stf
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FrameEvent tell what a Frame carries
+type FrameEvent int
+
+const (
+	FRAME_IMAGE    FrameEvent = iota // Data is a jpeg image
+	FRAME_ROTATION                   // device rotated to Rotation, Data is empty
+)
+
+// Frame is a single jpeg image with its capture metadata
+type Frame struct {
+	Event         FrameEvent
+	Seq           uint64    // monotonically increasing, starts from 1
+	Time          time.Time // when the frame was received by host
+	Size          int       // jpeg size in bytes
+	Rotation      int       // 0, 90, 180, 270
+	RealWidth     int
+	RealHeight    int
+	VirtualWidth  int
+	VirtualHeight int
+	Data          []byte // jpeg payload
+
+	once sync.Once
+	img  image.Image
+	err  error
+}
+
+// Image decode jpeg data, result is cached
+func (f *Frame) Image() (image.Image, error) {
+	if f.Event != FRAME_IMAGE {
+		return nil, errors.New("frame has no image")
+	}
+	f.once.Do(func() {
+		f.img, f.err = jpeg.Decode(bytes.NewReader(f.Data))
+	})
+	return f.img, f.err
+}
+
+// frameHub keep the latest frame and fan out frames to subscribers.
+// Capture backends share it so that ScreenReader methods are implemented only once.
+type frameHub struct {
+	mu        sync.Mutex
+	running   bool
+	seq       uint64
+	lastFrame *Frame
+	imgSubs   map[chan image.Image]*Subscription
+	bc        *Broadcaster
+}
+
+func newFrameHub() *frameHub {
+	return &frameHub{
+		imgSubs: make(map[chan image.Image]*Subscription),
+		bc:      NewBroadcaster(),
+	}
+}
+
+// open is called by backend when start producing frames
+func (h *frameHub) open() {
+	h.mu.Lock()
+	h.running = true
+	h.mu.Unlock()
+}
+
+// close is called by backend when quit, all subscriptions will be closed
+func (h *frameHub) close() {
+	h.mu.Lock()
+	h.running = false
+	h.imgSubs = make(map[chan image.Image]*Subscription)
+	h.mu.Unlock()
+	h.bc.UnsubscribeAll()
+}
+
+func (h *frameHub) isRunning() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.running
+}
+
+// pub assign sequence number to frame and send it to all subscribers
+func (h *frameHub) pub(f *Frame) {
+	h.mu.Lock()
+	h.seq++
+	f.Seq = h.seq
+	if f.Event == FRAME_IMAGE {
+		h.lastFrame = f
+	}
+	h.mu.Unlock()
+	h.bc.Publish(f)
+}
+
+// pubRotation put a rotation event into frame stream, so viewers can re-layout
+func (h *frameHub) pubRotation(rotation int) {
+	f := &Frame{Event: FRAME_ROTATION, Time: time.Now(), Rotation: rotation}
+	if last, err := h.LastFrame(); err == nil {
+		f.RealWidth, f.RealHeight = last.RealWidth, last.RealHeight
+		f.VirtualWidth, f.VirtualHeight = last.VirtualWidth, last.VirtualHeight
+	}
+	h.pub(f)
+}
+
+func (h *frameHub) lastSeq() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.seq
+}
+
+// LastFrame return the latest received frame, even if nobody read it
+func (h *frameHub) LastFrame() (*Frame, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastFrame == nil {
+		return nil, errors.New("no image captured yet")
+	}
+	return h.lastFrame, nil
+}
+
+// SubscribeFrames return a frame subscription with its own queue.
+// The subscription is closed when capturer stopped.
+func (h *frameHub) SubscribeFrames(policy BackpressurePolicy, size int) *Subscription {
+	return h.bc.Subscribe(policy, size)
+}
+
+func (h *frameHub) UnsubscribeFrames(sub *Subscription) {
+	h.bc.Unsubscribe(sub)
+}
+
+// subscribeRunning subscribe only when running, so the subscription is always closed by close
+func (h *frameHub) subscribeRunning(policy BackpressurePolicy, size int) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.running {
+		return nil, ErrServiceNotStarted
+	}
+	return h.SubscribeFrames(policy, size), nil
+}
+
+// NextImage block until a new image arrived
+func (h *frameHub) NextImage() (image.Image, error) {
+	sub, err := h.subscribeRunning(LatestOnly, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer h.UnsubscribeFrames(sub)
+	for f := range sub.C {
+		if f.Event == FRAME_IMAGE {
+			return f.Image()
+		}
+	}
+	return nil, errors.New("capturer stopped")
+}
+
+// LastImage return the latest captured image
+func (h *frameHub) LastImage() (image.Image, error) {
+	f, err := h.LastFrame()
+	if err != nil {
+		return nil, err
+	}
+	return f.Image()
+}
+
+// Subscribe return a channel of decoded images.
+// Images will be dropped if the receiver is too slow.
+func (h *frameHub) Subscribe() (chan image.Image, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := h.SubscribeFrames(LatestOnly, 1)
+	imgC := make(chan image.Image, 1)
+	h.imgSubs[imgC] = sub
+	go func() {
+		defer close(imgC)
+		for f := range sub.C {
+			img, err := f.Image()
+			if err != nil {
+				continue
+			}
+			select {
+			case imgC <- img:
+			default:
+			}
+		}
+	}()
+	return imgC, nil
+}
+
+// Unsubscribe will also close channel
+func (h *frameHub) Unsubscribe(C chan image.Image) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub, ok := h.imgSubs[C]
+	if !ok {
+		return
+	}
+	delete(h.imgSubs, C)
+	h.UnsubscribeFrames(sub)
+}
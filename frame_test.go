@@ -0,0 +1,60 @@
+package stf
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameImage(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, 4, 3)), nil)
+	assert.NoError(t, err)
+
+	f := &Frame{Data: buf.Bytes(), Size: buf.Len()}
+	img, err := f.Image()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, img.Bounds().Dx())
+	assert.Equal(t, 3, img.Bounds().Dy())
+
+	_, err = (&Frame{Data: []byte("bad")}).Image()
+	assert.Error(t, err)
+}
+
+func TestFrameHubNextImage(t *testing.T) {
+	h := newFrameHub()
+	_, err := h.NextImage()
+	assert.Equal(t, ErrServiceNotStarted, err)
+
+	// NextImage must not block forever when hub is closed at the same time
+	for i := 0; i < 100; i++ {
+		h.open()
+		errC := make(chan error, 1)
+		go func() {
+			_, err := h.NextImage()
+			errC <- err
+		}()
+		h.close()
+		select {
+		case err := <-errC:
+			assert.Error(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("NextImage blocked after close")
+		}
+	}
+}
+
+func TestFrameHubPubRotation(t *testing.T) {
+	h := newFrameHub()
+	h.pub(&Frame{RealWidth: 1080, RealHeight: 1920, VirtualWidth: 540, VirtualHeight: 960})
+	sub := h.SubscribeFrames(DropOldest, 4)
+	h.pubRotation(90)
+	f := <-sub.C
+	assert.Equal(t, FRAME_ROTATION, f.Event)
+	assert.Equal(t, 90, f.Rotation)
+	assert.Equal(t, []int{1080, 1920, 540, 960}, []int{f.RealWidth, f.RealHeight, f.VirtualWidth, f.VirtualHeight})
+}
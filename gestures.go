@@ -0,0 +1,220 @@
+package stf
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Easing map progress [0, 1] to position progress [0, 1]
+type Easing func(t float64) float64
+
+var (
+	EaseLinear    Easing = func(t float64) float64 { return t }
+	EaseInQuad    Easing = func(t float64) float64 { return t * t }
+	EaseOutQuad   Easing = func(t float64) float64 { return t * (2 - t) }
+	EaseInOutQuad Easing = func(t float64) float64 {
+		if t < 0.5 {
+			return 2 * t * t
+		}
+		return -1 + (4-2*t)*t
+	}
+	EaseInOutSine Easing = func(t float64) float64 { return -(math.Cos(math.Pi*t) - 1) / 2 }
+)
+
+const (
+	defaultGestureDuration = 300 * time.Millisecond
+	defaultGestureStepTime = 20 * time.Millisecond
+	defaultTapDuration     = 50 * time.Millisecond
+	defaultDoubleTapGap    = 100 * time.Millisecond
+	defaultLongPress       = time.Second
+	defaultFlingDuration   = 100 * time.Millisecond
+)
+
+// GestureOptions control how a moving gesture is performed, zero values use defaults
+type GestureOptions struct {
+	Duration time.Duration // total time of moving, default 300ms
+	Steps    int           // number of moves, default one move every 20ms
+	Easing   Easing        // default EaseLinear
+	Hold     time.Duration // keep pressing before moving, used by drag
+}
+
+func (o *GestureOptions) withDefaults() GestureOptions {
+	var opts GestureOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = defaultGestureDuration
+	}
+	if opts.Steps <= 0 {
+		opts.Steps = int(opts.Duration / defaultGestureStepTime)
+		if opts.Steps < 1 {
+			opts.Steps = 1
+		}
+	}
+	if opts.Easing == nil {
+		opts.Easing = EaseLinear
+	}
+	return opts
+}
+
+// Path return contact position at progress t, t in range [0, 1]
+type Path func(t float64) (x, y float64)
+
+// LinePath move from (x1, y1) to (x2, y2)
+func LinePath(x1, y1, x2, y2 float64) Path {
+	return func(t float64) (float64, float64) {
+		return x1 + (x2-x1)*t, y1 + (y2-y1)*t
+	}
+}
+
+// Gestures perform common gestures with a Toucher.
+// All coordinates are percentage of screen size.
+type Gestures struct {
+	toucher Toucher
+	sleep   func(time.Duration)
+}
+
+func NewGestures(t Toucher) *Gestures {
+	return &Gestures{toucher: t, sleep: time.Sleep}
+}
+
+// aspect return width/height, used to draw circles in percentage coordinates
+func (g *Gestures) aspect() float64 {
+	w, h := g.toucher.Size()
+	if w == 0 || h == 0 {
+		return 1
+	}
+	return float64(w) / float64(h)
+}
+
+// Run use device side timing if toucher is a ScriptRunner, otherwise replay script on host
+func (g *Gestures) Run(script *TouchScript) error {
+	if r, ok := g.toucher.(ScriptRunner); ok {
+		return r.RunScript(script)
+	}
+	return script.replay(g.toucher, g.sleep)
+}
+
+// PathScript build script which move every contact along its path at the same time,
+// contact index is the index of path
+func PathScript(paths []Path, o *GestureOptions) *TouchScript {
+	opts := o.withDefaults()
+	script := NewTouchScript()
+	for i, path := range paths {
+		x, y := path(0)
+		script.Down(i, x, y)
+	}
+	script.Commit().Wait(opts.Hold)
+	interval := opts.Duration / time.Duration(opts.Steps)
+	for step := 1; step <= opts.Steps; step++ {
+		script.Wait(interval)
+		t := opts.Easing(float64(step) / float64(opts.Steps))
+		for i, path := range paths {
+			x, y := path(t)
+			script.Move(i, x, y)
+		}
+		script.Commit()
+	}
+	for i := range paths {
+		script.Up(i)
+	}
+	return script.Commit()
+}
+
+// Perform move every contact along its path at the same time
+func (g *Gestures) Perform(paths []Path, opts *GestureOptions) error {
+	if len(paths) == 0 {
+		return errors.New("no contact to perform")
+	}
+	return g.Run(PathScript(paths, opts))
+}
+
+func tapScript(script *TouchScript, x, y float64, d time.Duration) *TouchScript {
+	return script.Down(0, x, y).Commit().Wait(d).Up(0).Commit()
+}
+
+func (g *Gestures) Tap(x, y float64) error {
+	return g.Run(tapScript(NewTouchScript(), x, y, defaultTapDuration))
+}
+
+func (g *Gestures) DoubleTap(x, y float64) error {
+	script := tapScript(NewTouchScript(), x, y, defaultTapDuration).Wait(defaultDoubleTapGap)
+	return g.Run(tapScript(script, x, y, defaultTapDuration))
+}
+
+// LongPress duration 0 means 1s
+func (g *Gestures) LongPress(x, y float64, d time.Duration) error {
+	if d <= 0 {
+		d = defaultLongPress
+	}
+	return g.Run(tapScript(NewTouchScript(), x, y, d))
+}
+
+func (g *Gestures) Swipe(x1, y1, x2, y2 float64, opts *GestureOptions) error {
+	return g.Perform([]Path{LinePath(x1, y1, x2, y2)}, opts)
+}
+
+// Drag is a swipe which long press before moving
+func (g *Gestures) Drag(x1, y1, x2, y2 float64, opts *GestureOptions) error {
+	o := opts.withDefaults()
+	if o.Hold <= 0 {
+		o.Hold = defaultLongPress
+	}
+	return g.Swipe(x1, y1, x2, y2, &o)
+}
+
+// Fling is a quick accelerating swipe
+func (g *Gestures) Fling(x1, y1, x2, y2 float64) error {
+	return g.Swipe(x1, y1, x2, y2, &GestureOptions{
+		Duration: defaultFlingDuration,
+		Easing:   EaseInQuad,
+	})
+}
+
+// Pinch two fingers on a horizontal line through (cx, cy),
+// radius is the distance between finger and center in percentage of screen width
+func (g *Gestures) Pinch(cx, cy, fromRadius, toRadius float64, opts *GestureOptions) error {
+	radius := func(t float64) float64 { return fromRadius + (toRadius-fromRadius)*t }
+	return g.Perform([]Path{
+		func(t float64) (float64, float64) { return cx - radius(t), cy },
+		func(t float64) (float64, float64) { return cx + radius(t), cy },
+	}, opts)
+}
+
+// PinchIn zoom out, fingers move to center
+func (g *Gestures) PinchIn(cx, cy, radius float64, opts *GestureOptions) error {
+	return g.Pinch(cx, cy, radius, radius/4, opts)
+}
+
+// PinchOut zoom in, fingers move away from center
+func (g *Gestures) PinchOut(cx, cy, radius float64, opts *GestureOptions) error {
+	return g.Pinch(cx, cy, radius/4, radius, opts)
+}
+
+// Rotate two fingers around (cx, cy), angles are in degrees, clockwise
+func (g *Gestures) Rotate(cx, cy, radius, fromAngle, toAngle float64, opts *GestureOptions) error {
+	aspect := g.aspect()
+	finger := func(offset float64) Path {
+		return func(t float64) (float64, float64) {
+			rad := (fromAngle + (toAngle-fromAngle)*t + offset) * math.Pi / 180
+			return cx + radius*math.Cos(rad), cy + radius*math.Sin(rad)*aspect
+		}
+	}
+	return g.Perform([]Path{finger(0), finger(180)}, opts)
+}
+
+// Scroll with fingers side by side, spacing is distance between fingers in percentage of screen width
+func (g *Gestures) Scroll(fingers int, x1, y1, x2, y2, spacing float64, opts *GestureOptions) error {
+	if fingers <= 0 {
+		return errors.New("fingers must be positive")
+	}
+	paths := make([]Path, fingers)
+	for i := range paths {
+		offset := (float64(i) - float64(fingers-1)/2) * spacing
+		paths[i] = LinePath(x1+offset, y1, x2+offset, y2)
+	}
+	return g.Perform(paths, opts)
+}
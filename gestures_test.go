@@ -0,0 +1,92 @@
+package stf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeToucher record every commit
+type fakeToucher struct {
+	commits [][]TouchEvent
+}
+
+func (f *fakeToucher) Start() error { return nil }
+func (f *fakeToucher) Stop() error  { return nil }
+func (f *fakeToucher) Wait() error  { return nil }
+
+func (f *fakeToucher) Down(index int, xP, yP float64) error {
+	return f.Do(TouchEvent{Action: TOUCH_DOWN, Index: index, X: xP, Y: yP})
+}
+
+func (f *fakeToucher) Move(index int, xP, yP float64) error {
+	return f.Do(TouchEvent{Action: TOUCH_MOVE, Index: index, X: xP, Y: yP})
+}
+
+func (f *fakeToucher) Up(index int) error {
+	return f.Do(TouchEvent{Action: TOUCH_UP, Index: index})
+}
+
+func (f *fakeToucher) DownPx(index int, x, y int) error { return nil }
+func (f *fakeToucher) MovePx(index int, x, y int) error { return nil }
+func (f *fakeToucher) Size() (int, int)                 { return 1000, 2000 }
+
+func (f *fakeToucher) Do(events ...TouchEvent) error {
+	f.commits = append(f.commits, append([]TouchEvent(nil), events...))
+	return nil
+}
+
+func newFakeGestures() (*Gestures, *fakeToucher, *time.Duration) {
+	touch := &fakeToucher{}
+	g := NewGestures(touch)
+	var slept time.Duration
+	g.sleep = func(d time.Duration) { slept += d }
+	return g, touch, &slept
+}
+
+func TestGesturesSwipe(t *testing.T) {
+	g, touch, slept := newFakeGestures()
+	err := g.Swipe(0.1, 0.5, 0.9, 0.5, &GestureOptions{Duration: 400 * time.Millisecond, Steps: 4})
+	assert.NoError(t, err)
+	assert.Len(t, touch.commits, 6)
+	assert.Equal(t, TouchEvent{Action: TOUCH_DOWN, Index: 0, X: 0.1, Y: 0.5}, touch.commits[0][0])
+	assert.Equal(t, TOUCH_MOVE, touch.commits[4][0].Action)
+	assert.InDelta(t, 0.9, touch.commits[4][0].X, 1e-9)
+	assert.Equal(t, TOUCH_UP, touch.commits[5][0].Action)
+	assert.Equal(t, 400*time.Millisecond, *slept)
+}
+
+func TestGesturesMultiFinger(t *testing.T) {
+	g, touch, _ := newFakeGestures()
+	assert.NoError(t, g.PinchOut(0.5, 0.5, 0.4, &GestureOptions{Steps: 2}))
+	for _, commit := range touch.commits {
+		assert.Len(t, commit, 2) // both fingers in every commit
+	}
+	last := touch.commits[len(touch.commits)-2]
+	assert.InDelta(t, 0.1, last[0].X, 1e-9)
+	assert.InDelta(t, 0.9, last[1].X, 1e-9)
+
+	g, touch, _ = newFakeGestures()
+	assert.NoError(t, g.Rotate(0.5, 0.5, 0.2, 0, 90, &GestureOptions{Steps: 1}))
+	assert.InDelta(t, 0.7, touch.commits[0][0].X, 1e-9)
+	assert.InDelta(t, 0.5, touch.commits[1][0].X, 1e-9)
+	assert.InDelta(t, 0.6, touch.commits[1][0].Y, 1e-9) // y radius is scaled by screen aspect
+
+	g, touch, _ = newFakeGestures()
+	assert.NoError(t, g.Scroll(3, 0.5, 0.8, 0.5, 0.2, 0.1, nil))
+	assert.Len(t, touch.commits[0], 3)
+	assert.InDelta(t, 0.4, touch.commits[0][0].X, 1e-9)
+	assert.InDelta(t, 0.6, touch.commits[0][2].X, 1e-9)
+}
+
+func TestGesturesTap(t *testing.T) {
+	g, touch, slept := newFakeGestures()
+	assert.NoError(t, g.DoubleTap(0.5, 0.5))
+	assert.Len(t, touch.commits, 4)
+	assert.Equal(t, 2*defaultTapDuration+defaultDoubleTapGap, *slept)
+
+	assert.Equal(t, 0.0, EaseInOutQuad(0))
+	assert.Equal(t, 1.0, EaseOutQuad(1))
+	assert.InDelta(t, 0.5, EaseInOutSine(0.5), 1e-9)
+}
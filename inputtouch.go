@@ -0,0 +1,407 @@
+package stf
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	adb "github.com/openatx/go-adb"
+	"github.com/pkg/errors"
+)
+
+var ErrMultiTouchNotSupported = errors.New("multi-touch not supported")
+
+const (
+	defaultTouchReadyTimeout = 5 * time.Second
+	inputLongPressDuration   = 500 * time.Millisecond
+	inputTapSlop             = 10 // pixels, moving less than it is still a tap
+)
+
+var (
+	_ CapableToucher = (*InputToucher)(nil)
+	_ CapableToucher = (*AutoToucher)(nil)
+	_ ScriptRunner   = (*AutoToucher)(nil)
+)
+
+// InputToucher send touch events with "adb shell input", only one contact is supported.
+// "input motionevent" is used when the device supports it,
+// otherwise a down/up pair is converted to "input tap" or "input swipe" when contact up.
+type InputToucher struct {
+	mu            sync.Mutex
+	width, height int // natural orientation
+	rotation      int
+	motionEvent   bool
+	pressed       bool
+	downX, downY  int
+	lastX, lastY  int
+	downAt        time.Time
+
+	run func(name string, args ...string) (string, error)
+	now func() time.Time
+
+	*adb.Device
+	errorMixin
+	safeMixin
+}
+
+func NewInputToucher(device *adb.Device) *InputToucher {
+	s := &InputToucher{Device: device, now: time.Now}
+	s.run = func(name string, args ...string) (string, error) {
+		return AdbCheckOutput(s.Device, name, args...)
+	}
+	return s
+}
+
+func (s *InputToucher) Start() error {
+	return s.safeDo(_ACTION_START, func() error {
+		s.resetError()
+		s.watchState(s.Wait)
+		out, err := s.run("wm", "size")
+		if err != nil {
+			return errors.Wrap(err, "wm size")
+		}
+		width, height, err := parseWmSize(out)
+		if err != nil {
+			return err
+		}
+		// input print usage with a non-zero exit code on some devices
+		usage, _ := s.run("input")
+		s.mu.Lock()
+		s.width, s.height = width, height
+		s.motionEvent = strings.Contains(usage, "motionevent")
+		s.pressed = false
+		s.mu.Unlock()
+		return nil
+	})
+}
+
+func (s *InputToucher) Stop() error {
+	return s.safeDo(_ACTION_STOP, func() error {
+		s.doneNilError()
+		return s.Wait()
+	})
+}
+
+// parseWmSize parse output of "wm size", override size is preferred
+//
+//	Physical size: 1080x1920
+//	Override size: 720x1280
+func parseWmSize(out string) (width, height int, err error) {
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var w, h int
+		if _, er := fmt.Sscanf(strings.TrimSpace(parts[1]), "%dx%d", &w, &h); er != nil {
+			continue
+		}
+		if width == 0 || strings.Contains(parts[0], "Override") {
+			width, height = w, h
+		}
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, errors.New("parse wm size failed: " + strconv.Quote(out))
+	}
+	return
+}
+
+func (s *InputToucher) SetRotation(r int) {
+	s.mu.Lock()
+	s.rotation = r
+	s.mu.Unlock()
+}
+
+// Size return screen size in pixels of current rotation
+func (s *InputToucher) Size() (width, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size()
+}
+
+func (s *InputToucher) size() (width, height int) {
+	if s.rotation == 90 || s.rotation == 270 {
+		return s.height, s.width
+	}
+	return s.width, s.height
+}
+
+func (s *InputToucher) Capabilities() TouchCapabilities {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return TouchCapabilities{MaxContacts: 1, Move: s.motionEvent}
+}
+
+func (s *InputToucher) pixel(xP, yP float64) (x, y int) {
+	width, height := s.size()
+	return int(xP * float64(width)), int(yP * float64(height))
+}
+
+func (s *InputToucher) input(args ...string) error {
+	_, err := s.run("input", args...)
+	return err
+}
+
+// handle must be called with mu held
+func (s *InputToucher) handle(e TouchEvent) error {
+	if e.Index != 0 {
+		return ErrMultiTouchNotSupported
+	}
+	x, y := s.lastX, s.lastY
+	if e.Action != TOUCH_UP {
+		x, y = s.pixel(e.X, e.Y)
+	}
+	switch e.Action {
+	case TOUCH_DOWN:
+		s.pressed = true
+		s.downX, s.downY, s.downAt = x, y, s.now()
+	case TOUCH_MOVE:
+		if !s.pressed {
+			return errors.New("move before down")
+		}
+	case TOUCH_UP:
+		if !s.pressed {
+			return nil
+		}
+		s.pressed = false
+	}
+	s.lastX, s.lastY = x, y
+	if s.motionEvent {
+		action := map[TouchAction]string{TOUCH_DOWN: "DOWN", TOUCH_MOVE: "MOVE", TOUCH_UP: "UP"}[e.Action]
+		return s.input("motionevent", action, strconv.Itoa(x), strconv.Itoa(y))
+	}
+	if e.Action != TOUCH_UP {
+		return nil
+	}
+	// no real time feedback, replay the whole touch when contact up
+	duration := s.now().Sub(s.downAt)
+	moved := math.Hypot(float64(x-s.downX), float64(y-s.downY)) > inputTapSlop
+	if !moved && duration < inputLongPressDuration {
+		return s.input("tap", strconv.Itoa(x), strconv.Itoa(y))
+	}
+	return s.input("swipe", strconv.Itoa(s.downX), strconv.Itoa(s.downY),
+		strconv.Itoa(x), strconv.Itoa(y), strconv.Itoa(int(duration/time.Millisecond)))
+}
+
+// Do events are executed one by one, multi-touch is not supported
+func (s *InputToucher) Do(events ...TouchEvent) error {
+	if !s.IsStarted() {
+		return ErrServiceNotStarted
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range events {
+		if err := s.handle(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *InputToucher) Down(index int, xP, yP float64) error {
+	return s.Do(TouchEvent{Action: TOUCH_DOWN, Index: index, X: xP, Y: yP})
+}
+
+func (s *InputToucher) Move(index int, xP, yP float64) error {
+	return s.Do(TouchEvent{Action: TOUCH_MOVE, Index: index, X: xP, Y: yP})
+}
+
+func (s *InputToucher) Up(index int) error {
+	return s.Do(TouchEvent{Action: TOUCH_UP, Index: index})
+}
+
+func (s *InputToucher) percent(x, y int) (float64, float64, error) {
+	width, height := s.Size()
+	if width == 0 || height == 0 {
+		return 0, 0, ErrTouchNotReady
+	}
+	return float64(x) / float64(width), float64(y) / float64(height), nil
+}
+
+func (s *InputToucher) DownPx(index int, x, y int) error {
+	xP, yP, err := s.percent(x, y)
+	if err != nil {
+		return err
+	}
+	return s.Down(index, xP, yP)
+}
+
+func (s *InputToucher) MovePx(index int, x, y int) error {
+	xP, yP, err := s.percent(x, y)
+	if err != nil {
+		return err
+	}
+	return s.Move(index, xP, yP)
+}
+
+// Tap with "input tap" directly
+func (s *InputToucher) Tap(xP, yP float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, y := s.pixel(xP, yP)
+	return s.input("tap", strconv.Itoa(x), strconv.Itoa(y))
+}
+
+// Swipe with "input swipe" directly
+func (s *InputToucher) Swipe(x1P, y1P, x2P, y2P float64, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x1, y1 := s.pixel(x1P, y1P)
+	x2, y2 := s.pixel(x2P, y2P)
+	return s.input("swipe", strconv.Itoa(x1), strconv.Itoa(y1),
+		strconv.Itoa(x2), strconv.Itoa(y2), strconv.Itoa(int(d/time.Millisecond)))
+}
+
+// AutoToucher use minitouch if it works on the device, otherwise fall back to InputToucher
+type AutoToucher struct {
+	mu        sync.RWMutex
+	active    CapableToucher
+	last      CapableToucher // backend of the latest start, Wait still use it after Stop
+	minitouch *STFTouch
+	input     *InputToucher
+	// ReadyTimeout is how long to wait for minitouch before falling back
+	ReadyTimeout time.Duration
+
+	safeMixin
+}
+
+// NewAutoToucher provider can be nil to use DefaultBinaryProvider
+func NewAutoToucher(device *adb.Device, provider BinaryProvider) *AutoToucher {
+	return &AutoToucher{
+		minitouch:    NewSTFTouch(device, provider),
+		input:        NewInputToucher(device),
+		ReadyTimeout: defaultTouchReadyTimeout,
+	}
+}
+
+// SetLogger set logger of minitouch, nil means slog.Default()
+func (a *AutoToucher) SetLogger(l Logger) {
+	a.minitouch.SetLogger(l)
+}
+
+func (a *AutoToucher) Start() error {
+	return a.safeDo(_ACTION_START, func() error {
+		a.watchState(a.Wait)
+		err := a.minitouch.Start()
+		if err == nil {
+			if err = a.minitouch.WaitReady(a.ReadyTimeout); err == nil {
+				a.setActive(a.minitouch)
+				return nil
+			}
+			a.minitouch.Stop()
+		}
+		if er := a.input.Start(); er != nil {
+			return wrapMultiError(err, er)
+		}
+		a.setActive(a.input)
+		return nil
+	})
+}
+
+func (a *AutoToucher) Stop() error {
+	return a.safeDo(_ACTION_STOP, func() error {
+		err := a.Backend().Stop()
+		a.setActive(nil)
+		return err
+	})
+}
+
+func (a *AutoToucher) setActive(t CapableToucher) {
+	a.mu.Lock()
+	a.active = t
+	if t != nil {
+		a.last = t
+	}
+	a.mu.Unlock()
+}
+
+// Wait return error of the backend, after Stop it is the result of stopping
+func (a *AutoToucher) Wait() error {
+	a.mu.RLock()
+	t := a.last
+	a.mu.RUnlock()
+	if t == nil {
+		return ErrServiceNotStarted
+	}
+	return t.Wait()
+}
+
+// Backend return the toucher in use, nil if not started
+func (a *AutoToucher) Backend() CapableToucher {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.active
+}
+
+func (a *AutoToucher) backend() (CapableToucher, error) {
+	if t := a.Backend(); t != nil {
+		return t, nil
+	}
+	return nil, ErrServiceNotStarted
+}
+
+func (a *AutoToucher) SetRotation(r int) {
+	a.minitouch.SetRotation(r)
+	a.input.SetRotation(r)
+}
+
+func (a *AutoToucher) Down(index int, xP, yP float64) error {
+	return a.Do(TouchEvent{Action: TOUCH_DOWN, Index: index, X: xP, Y: yP})
+}
+
+func (a *AutoToucher) Move(index int, xP, yP float64) error {
+	return a.Do(TouchEvent{Action: TOUCH_MOVE, Index: index, X: xP, Y: yP})
+}
+
+func (a *AutoToucher) Up(index int) error {
+	return a.Do(TouchEvent{Action: TOUCH_UP, Index: index})
+}
+
+func (a *AutoToucher) DownPx(index int, x, y int) error {
+	t, err := a.backend()
+	if err != nil {
+		return err
+	}
+	return t.DownPx(index, x, y)
+}
+
+func (a *AutoToucher) MovePx(index int, x, y int) error {
+	t, err := a.backend()
+	if err != nil {
+		return err
+	}
+	return t.MovePx(index, x, y)
+}
+
+func (a *AutoToucher) Do(events ...TouchEvent) error {
+	t, err := a.backend()
+	if err != nil {
+		return err
+	}
+	return t.Do(events...)
+}
+
+func (a *AutoToucher) Size() (width, height int) {
+	if t := a.Backend(); t != nil {
+		return t.Size()
+	}
+	return 0, 0
+}
+
+func (a *AutoToucher) Capabilities() TouchCapabilities {
+	if t := a.Backend(); t != nil {
+		return t.Capabilities()
+	}
+	return TouchCapabilities{}
+}
+
+func (a *AutoToucher) RunScript(script *TouchScript) error {
+	t, err := a.backend()
+	if err != nil {
+		return err
+	}
+	return NewGestures(t).Run(script)
+}
@@ -0,0 +1,95 @@
+package stf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWmSize(t *testing.T) {
+	w, h, err := parseWmSize("Physical size: 1080x1920\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 1080, w)
+	assert.Equal(t, 1920, h)
+
+	w, h, err = parseWmSize("Physical size: 1080x1920\r\nOverride size: 720x1280\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 720, w)
+	assert.Equal(t, 1280, h)
+
+	_, _, err = parseWmSize("error")
+	assert.Error(t, err)
+}
+
+func newFakeInputToucher(usage string) (*InputToucher, *[]string) {
+	var cmds []string
+	s := NewInputToucher(nil)
+	s.run = func(name string, args ...string) (string, error) {
+		switch name {
+		case "wm":
+			return "Physical size: 1000x2000", nil
+		case "input":
+			if len(args) == 0 {
+				return usage, nil
+			}
+		}
+		cmds = append(cmds, name+" "+strings.Join(args, " "))
+		return "", nil
+	}
+	return s, &cmds
+}
+
+func TestInputToucherEmulate(t *testing.T) {
+	s, cmds := newFakeInputToucher("Usage: input [<source>] <command> [<arg>...]\n tap\n swipe")
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+	assert.NoError(t, s.Start())
+	assert.False(t, s.Capabilities().Move)
+
+	assert.Equal(t, ErrMultiTouchNotSupported, s.Down(1, 0.5, 0.5))
+	assert.NoError(t, s.Down(0, 0.5, 0.5))
+	assert.NoError(t, s.Up(0))
+
+	s.SetRotation(90)
+	assert.NoError(t, s.Down(0, 0.1, 0.1))
+	now = now.Add(300 * time.Millisecond)
+	assert.NoError(t, s.Move(0, 0.5, 0.1))
+	assert.NoError(t, s.Up(0))
+	assert.Equal(t, []string{"input tap 500 1000", "input swipe 200 100 1000 100 300"}, *cmds)
+	assert.NoError(t, s.Stop())
+	assert.NoError(t, s.Wait())
+}
+
+func TestInputToucherMotionEvent(t *testing.T) {
+	s, cmds := newFakeInputToucher("motionevent <DOWN|UP|MOVE> <x> <y>")
+	assert.NoError(t, s.Start())
+	assert.True(t, s.Capabilities().Move)
+	assert.False(t, s.Capabilities().MultiTouch)
+	assert.NoError(t, s.Do(
+		TouchEvent{Action: TOUCH_DOWN, X: 0.1, Y: 0.1},
+		TouchEvent{Action: TOUCH_MOVE, X: 0.2, Y: 0.2},
+		TouchEvent{Action: TOUCH_UP}))
+	assert.Equal(t, []string{
+		"input motionevent DOWN 100 200",
+		"input motionevent MOVE 200 400",
+		"input motionevent UP 200 400",
+	}, *cmds)
+}
+
+func TestAutoToucherWaitAfterStop(t *testing.T) {
+	input, _ := newFakeInputToucher("")
+	a := &AutoToucher{input: input}
+	assert.Equal(t, ErrServiceNotStarted, a.Wait())
+	assert.NoError(t, a.safeDo(_ACTION_START, func() error {
+		if err := input.Start(); err != nil {
+			return err
+		}
+		a.setActive(input)
+		return nil
+	}))
+	assert.NoError(t, a.Stop())
+	assert.Nil(t, a.Backend())
+	assert.NoError(t, a.Wait())
+}
@@ -0,0 +1,256 @@
+package stf
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	adb "github.com/openatx/go-adb"
+	"github.com/pkg/errors"
+)
+
+// Checksum of an artifact, empty hash fields are not compared
+type Checksum struct {
+	Size int64  `json:"size"`
+	MD5  string `json:"md5,omitempty"`
+	SHA1 string `json:"sha1,omitempty"`
+}
+
+func checksumOf(data []byte) Checksum {
+	md5sum := md5.Sum(data)
+	sha1sum := sha1.Sum(data)
+	return Checksum{
+		Size: int64(len(data)),
+		MD5:  hex.EncodeToString(md5sum[:]),
+		SHA1: hex.EncodeToString(sha1sum[:]),
+	}
+}
+
+// Manifest map ArtifactPath to its checksum
+type Manifest map[string]Checksum
+
+// LoadManifest read manifest in json format, eg:
+//
+//	{"minitouch/arm64-v8a/minitouch": {"size": 51936, "md5": "..."}}
+func LoadManifest(rd io.Reader) (Manifest, error) {
+	var m Manifest
+	err := json.NewDecoder(rd).Decode(&m)
+	return m, err
+}
+
+// ChecksumProvider is optionally implemented by BinaryProvider,
+// so artifacts are not downloaded when device files are up to date
+type ChecksumProvider interface {
+	Checksum(name, abi, sdk string) (Checksum, bool)
+}
+
+// ContextChecksumProvider is optionally implemented by ChecksumProvider which ask remote servers
+type ContextChecksumProvider interface {
+	ChecksumContext(ctx context.Context, name, abi, sdk string) (Checksum, bool)
+}
+
+// artifactChecksum use ChecksumContext if p supports it
+func artifactChecksum(ctx context.Context, p BinaryProvider, name, abi, sdk string) (Checksum, bool) {
+	if cp, ok := p.(ContextChecksumProvider); ok {
+		return cp.ChecksumContext(ctx, name, abi, sdk)
+	}
+	if cp, ok := p.(ChecksumProvider); ok {
+		return cp.Checksum(name, abi, sdk)
+	}
+	return Checksum{}, false
+}
+
+type manifestProvider struct {
+	BinaryProvider
+	manifest Manifest
+}
+
+func (p *manifestProvider) OpenContext(ctx context.Context, name, abi, sdk string) (io.ReadCloser, error) {
+	return openArtifact(ctx, p.BinaryProvider, name, abi, sdk)
+}
+
+func (p *manifestProvider) Checksum(name, abi, sdk string) (Checksum, bool) {
+	return p.ChecksumContext(context.Background(), name, abi, sdk)
+}
+
+func (p *manifestProvider) ChecksumContext(ctx context.Context, name, abi, sdk string) (Checksum, bool) {
+	relPath, err := ArtifactPath(name, abi, sdk)
+	if err != nil {
+		return Checksum{}, false
+	}
+	if c, ok := p.manifest[relPath]; ok {
+		return c, true
+	}
+	return artifactChecksum(ctx, p.BinaryProvider, name, abi, sdk)
+}
+
+// WithManifest attach expected checksums to provider
+func WithManifest(p BinaryProvider, m Manifest) BinaryProvider {
+	return &manifestProvider{BinaryProvider: providerOrDefault(p), manifest: m}
+}
+
+// InstallResult report what Installer did for one artifact
+type InstallResult struct {
+	Name      string
+	Path      string
+	Installed bool   // false means device file is already up to date
+	Reason    string // eg: missing, size mismatch, md5 mismatch, up to date
+}
+
+// Installer push artifacts to device only when missing or outdated.
+// Files are written to a temporary name then renamed, so an interrupted push never leaves a broken binary.
+type Installer struct {
+	Device   *adb.Device
+	Provider BinaryProvider
+}
+
+func NewInstaller(d *adb.Device, provider BinaryProvider) *Installer {
+	return &Installer{Device: d, Provider: providerOrDefault(provider)}
+}
+
+func (in *Installer) read(ctx context.Context, name, abi, sdk string) ([]byte, error) {
+	rc, err := openArtifact(ctx, in.Provider, name, abi, sdk)
+	if err != nil {
+		return nil, errors.Wrap(err, "open "+name)
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// Install make sure dst on device has the same content as artifact
+func (in *Installer) Install(name, abi, sdk, dst string, perms os.FileMode) (res InstallResult, err error) {
+	return in.InstallContext(context.Background(), name, abi, sdk, dst, perms)
+}
+
+// InstallContext abort downloading and pushing when ctx is done
+func (in *Installer) InstallContext(ctx context.Context, name, abi, sdk, dst string, perms os.FileMode) (res InstallResult, err error) {
+	res = InstallResult{Name: name, Path: dst}
+	var content []byte
+	want, ok := artifactChecksum(ctx, in.Provider, name, abi, sdk)
+	if !ok {
+		// provider know nothing about the artifact, compare device file with the downloaded one
+		if content, err = in.read(ctx, name, abi, sdk); err != nil {
+			return
+		}
+		want = checksumOf(content)
+	}
+	var upToDate bool
+	if upToDate, res.Reason = in.verifyRemote(dst, want); upToDate {
+		return
+	}
+	if content == nil {
+		if content, err = in.read(ctx, name, abi, sdk); err != nil {
+			return
+		}
+		if ok && !want.match(checksumOf(content)) {
+			err = errors.New(name + " checksum not match with provider")
+			return
+		}
+	}
+	tmpPath := dst + ".tmp"
+	if err = PushFile(in.Device, tmpPath, perms, ctxReader{ctx, bytes.NewReader(content)}); err != nil {
+		return res, errors.Wrap(err, "push "+name)
+	}
+	if _, err = AdbCheckOutput(in.Device, "mv", tmpPath, dst); err != nil {
+		in.Device.RunCommand("rm", tmpPath)
+		return res, errors.Wrap(err, "rename "+name)
+	}
+	res.Installed = true
+	return
+}
+
+func (c Checksum) match(o Checksum) bool {
+	if c.Size > 0 && c.Size != o.Size {
+		return false
+	}
+	if c.MD5 != "" && !strings.EqualFold(c.MD5, o.MD5) {
+		return false
+	}
+	if c.SHA1 != "" && !strings.EqualFold(c.SHA1, o.SHA1) {
+		return false
+	}
+	return true
+}
+
+// verifyRemote compare device file with md5sum or sha1sum,
+// the file is pulled back and hashed locally when device has neither of them
+func (in *Installer) verifyRemote(dst string, want Checksum) (upToDate bool, reason string) {
+	st, err := in.Device.Stat(dst)
+	if err != nil {
+		return false, "missing"
+	}
+	return compareRemote(want, int64(st.Size), func(cmd string) (string, error) {
+		return AdbCheckOutput(in.Device, cmd, dst)
+	}, func() ([]byte, error) {
+		rd, err := in.Device.OpenRead(dst)
+		if err != nil {
+			return nil, err
+		}
+		defer rd.Close()
+		return ioutil.ReadAll(rd)
+	})
+}
+
+// compareRemote decide whether device file match want,
+// hash run md5sum or sha1sum on device, pull read the whole file
+func compareRemote(want Checksum, size int64, hash func(cmd string) (string, error), pull func() ([]byte, error)) (upToDate bool, reason string) {
+	if want.Size > 0 && size != want.Size {
+		return false, "size mismatch"
+	}
+	if want.MD5 == "" && want.SHA1 == "" {
+		return true, "up to date (size only)"
+	}
+	for _, h := range []struct {
+		cmd  string
+		want string
+	}{{"md5sum", want.MD5}, {"sha1sum", want.SHA1}} {
+		if h.want == "" {
+			continue
+		}
+		out, err := hash(h.cmd)
+		if err != nil {
+			continue // command not found on old devices
+		}
+		if !strings.EqualFold(parseHashOutput(out), h.want) {
+			return false, h.cmd[:len(h.cmd)-3] + " mismatch"
+		}
+		return true, "up to date"
+	}
+	data, err := pull()
+	if err != nil {
+		return false, "unverified"
+	}
+	if !want.match(checksumOf(data)) {
+		return false, "checksum mismatch"
+	}
+	return true, "up to date (pulled)"
+}
+
+// parseHashOutput parse output of md5sum, eg: "d41d8cd9...  /data/local/tmp/minicap"
+func parseHashOutput(out string) string {
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// installArtifact install artifact with checksum verification and log the result to l
+func installArtifact(ctx context.Context, l Logger, d *adb.Device, p BinaryProvider, name, abi, sdk, dst string, perms os.FileMode) error {
+	res, err := NewInstaller(d, p).InstallContext(ctx, name, abi, sdk, dst, perms)
+	if err != nil {
+		return err
+	}
+	if res.Installed {
+		l.Info("artifact installed",
+			"name", res.Name, "path", res.Path, "reason", res.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package stf
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksum(t *testing.T) {
+	c := checksumOf([]byte("hello"))
+	assert.Equal(t, int64(5), c.Size)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", c.MD5)
+	assert.True(t, Checksum{Size: 5}.match(c))
+	assert.True(t, Checksum{MD5: strings.ToUpper(c.MD5)}.match(c))
+	assert.False(t, Checksum{Size: 4}.match(c))
+	assert.False(t, Checksum{SHA1: "abc"}.match(c))
+
+	assert.Equal(t, c.MD5, parseHashOutput(c.MD5+"  /data/local/tmp/minicap\n"))
+	assert.Equal(t, "", parseHashOutput(""))
+}
+
+func TestManifest(t *testing.T) {
+	m, err := LoadManifest(strings.NewReader(`{"minitouch/x86/minitouch": {"size": 5, "md5": "5d41402abc4b2a76b9719d911017c592"}}`))
+	assert.NoError(t, err)
+
+	p := WithManifest(NewFSBinaryProvider(fstest.MapFS{}), m)
+	c, ok := p.(ChecksumProvider).Checksum(ARTIFACT_MINITOUCH, "x86", "23")
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), c.Size)
+	_, ok = p.(ChecksumProvider).Checksum(ARTIFACT_MINICAP, "x86", "23")
+	assert.False(t, ok)
+}
+
+func TestCompareRemote(t *testing.T) {
+	c := checksumOf([]byte("hello"))
+	noHash := func(string) (string, error) { return "", errors.New("md5sum: not found") }
+	pullHello := func() ([]byte, error) { return []byte("hello"), nil }
+	pullFail := func() ([]byte, error) { return nil, errors.New("permission denied") }
+
+	ok, reason := compareRemote(Checksum{Size: 5}, 5, noHash, pullFail)
+	assert.True(t, ok, reason)
+	ok, reason = compareRemote(c, 4, noHash, pullHello)
+	assert.False(t, ok)
+	assert.Equal(t, "size mismatch", reason)
+	ok, _ = compareRemote(c, 5, func(string) (string, error) { return c.MD5 + "  /data/local/tmp/x", nil }, pullFail)
+	assert.True(t, ok)
+	ok, reason = compareRemote(c, 5, func(string) (string, error) { return "0000  /data/local/tmp/x", nil }, pullHello)
+	assert.False(t, ok)
+	assert.Equal(t, "md5 mismatch", reason)
+
+	// no hash command on device, compare pulled content
+	ok, reason = compareRemote(c, 5, noHash, pullHello)
+	assert.True(t, ok, reason)
+	ok, _ = compareRemote(c, 5, noHash, func() ([]byte, error) { return []byte("world"), nil })
+	assert.False(t, ok)
+	ok, reason = compareRemote(c, 5, noHash, pullFail)
+	assert.False(t, ok)
+	assert.Equal(t, "unverified", reason)
+}
+
+func TestProviderChecksum(t *testing.T) {
+	fsys := fstest.MapFS{"minitouch/x86/minitouch": &fstest.MapFile{Data: []byte("hello")}}
+	c, ok := NewFSBinaryProvider(fsys).Checksum(ARTIFACT_MINITOUCH, "x86", "")
+	assert.True(t, ok)
+	assert.Equal(t, checksumOf([]byte("hello")), c)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "HEAD", r.Method)
+		w.Header().Set("Content-Length", "5")
+	}))
+	defer ts.Close()
+	c, ok = NewHTTPBinaryProvider(ts.URL).Checksum(ARTIFACT_MINITOUCH, "x86", "")
+	assert.True(t, ok)
+	assert.Equal(t, Checksum{Size: 5}, c)
+
+	// HEAD must not block Start forever
+	hang := make(chan bool)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer slow.Close()
+	defer close(hang)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, ok = NewHTTPBinaryProvider(slow.URL).ChecksumContext(ctx, ARTIFACT_MINITOUCH, "x86", "")
+	assert.False(t, ok)
+
+	// not in manifest, fall back to provider
+	p := WithManifest(NewFSBinaryProvider(fsys), Manifest{})
+	_, ok = p.(ChecksumProvider).Checksum(ARTIFACT_MINITOUCH, "x86", "")
+	assert.True(t, ok)
+}
@@ -0,0 +1,321 @@
+package stf
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	adb "github.com/openatx/go-adb"
+	"github.com/pkg/errors"
+)
+
+type TextMode int
+
+const (
+	TEXT_AUTO         TextMode = iota // "input text" for ascii, ADBKeyboard for the others
+	TEXT_INPUT                        // always "input text", only ascii is supported
+	TEXT_ADB_KEYBOARD                 // always ADBKeyboard broadcast
+)
+
+const adbKeyboardIME = "com.android.adbkeyboard/.AdbIME"
+
+var (
+	ErrUnknownKey           = errors.New("unknown key")
+	ErrAdbKeyboardNotActive = errors.New("ADBKeyboard is not installed or not enabled")
+)
+
+var androidKeyCodes = map[string]int{
+	"UNKNOWN": 0, "SOFT_LEFT": 1, "SOFT_RIGHT": 2, "HOME": 3, "BACK": 4, "CALL": 5, "ENDCALL": 6,
+	"STAR": 17, "POUND": 18, "DPAD_UP": 19, "DPAD_DOWN": 20, "DPAD_LEFT": 21, "DPAD_RIGHT": 22,
+	"DPAD_CENTER": 23, "VOLUME_UP": 24, "VOLUME_DOWN": 25, "POWER": 26, "CAMERA": 27, "CLEAR": 28,
+	"COMMA": 55, "PERIOD": 56, "ALT_LEFT": 57, "ALT_RIGHT": 58, "SHIFT_LEFT": 59, "SHIFT_RIGHT": 60,
+	"TAB": 61, "SPACE": 62, "SYM": 63, "EXPLORER": 64, "ENVELOPE": 65, "ENTER": 66, "DEL": 67,
+	"GRAVE": 68, "MINUS": 69, "EQUALS": 70, "LEFT_BRACKET": 71, "RIGHT_BRACKET": 72, "BACKSLASH": 73,
+	"SEMICOLON": 74, "APOSTROPHE": 75, "SLASH": 76, "AT": 77, "NUM": 78, "HEADSETHOOK": 79,
+	"FOCUS": 80, "PLUS": 81, "MENU": 82, "NOTIFICATION": 83, "SEARCH": 84, "MEDIA_PLAY_PAUSE": 85,
+	"MEDIA_STOP": 86, "MEDIA_NEXT": 87, "MEDIA_PREVIOUS": 88, "MEDIA_REWIND": 89,
+	"MEDIA_FAST_FORWARD": 90, "MUTE": 91, "PAGE_UP": 92, "PAGE_DOWN": 93, "ESCAPE": 111,
+	"FORWARD_DEL": 112, "CTRL_LEFT": 113, "CTRL_RIGHT": 114, "CAPS_LOCK": 115, "SCROLL_LOCK": 116,
+	"META_LEFT": 117, "META_RIGHT": 118, "FUNCTION": 119, "SYSRQ": 120, "BREAK": 121,
+	"MOVE_HOME": 122, "MOVE_END": 123, "INSERT": 124, "MEDIA_PLAY": 126, "MEDIA_PAUSE": 127,
+	"NUM_LOCK": 143, "NUMPAD_DIVIDE": 154, "NUMPAD_MULTIPLY": 155, "NUMPAD_SUBTRACT": 156,
+	"NUMPAD_ADD": 157, "NUMPAD_DOT": 158, "NUMPAD_COMMA": 159, "NUMPAD_ENTER": 160,
+	"NUMPAD_EQUALS": 161, "VOLUME_MUTE": 164, "APP_SWITCH": 187, "BRIGHTNESS_DOWN": 220,
+	"BRIGHTNESS_UP": 221, "SLEEP": 223, "WAKEUP": 224,
+}
+
+// w3cKeyCodes map KeyboardEvent.code to android keycode names
+var w3cKeyCodes = map[string]string{
+	"Enter": "ENTER", "Backspace": "DEL", "Delete": "FORWARD_DEL", "Tab": "TAB", "Space": "SPACE",
+	"Escape": "ESCAPE", "ArrowUp": "DPAD_UP", "ArrowDown": "DPAD_DOWN", "ArrowLeft": "DPAD_LEFT",
+	"ArrowRight": "DPAD_RIGHT", "ShiftLeft": "SHIFT_LEFT", "ShiftRight": "SHIFT_RIGHT",
+	"ControlLeft": "CTRL_LEFT", "ControlRight": "CTRL_RIGHT", "AltLeft": "ALT_LEFT",
+	"AltRight": "ALT_RIGHT", "MetaLeft": "META_LEFT", "MetaRight": "META_RIGHT",
+	"CapsLock": "CAPS_LOCK", "NumLock": "NUM_LOCK", "ScrollLock": "SCROLL_LOCK",
+	"PrintScreen": "SYSRQ", "Pause": "BREAK", "Insert": "INSERT", "Home": "MOVE_HOME",
+	"End": "MOVE_END", "PageUp": "PAGE_UP", "PageDown": "PAGE_DOWN", "Minus": "MINUS",
+	"Equal": "EQUALS", "BracketLeft": "LEFT_BRACKET", "BracketRight": "RIGHT_BRACKET",
+	"Backslash": "BACKSLASH", "Semicolon": "SEMICOLON", "Quote": "APOSTROPHE", "Slash": "SLASH",
+	"Comma": "COMMA", "Period": "PERIOD", "Backquote": "GRAVE", "NumpadDivide": "NUMPAD_DIVIDE",
+	"NumpadMultiply": "NUMPAD_MULTIPLY", "NumpadSubtract": "NUMPAD_SUBTRACT",
+	"NumpadAdd": "NUMPAD_ADD", "NumpadDecimal": "NUMPAD_DOT", "NumpadComma": "NUMPAD_COMMA",
+	"NumpadEnter": "NUMPAD_ENTER", "NumpadEqual": "NUMPAD_EQUALS", "ContextMenu": "MENU",
+	"AudioVolumeUp": "VOLUME_UP", "AudioVolumeDown": "VOLUME_DOWN", "AudioVolumeMute": "VOLUME_MUTE",
+	"MediaPlayPause": "MEDIA_PLAY_PAUSE", "MediaStop": "MEDIA_STOP", "MediaTrackNext": "MEDIA_NEXT",
+	"MediaTrackPrevious": "MEDIA_PREVIOUS", "BrowserBack": "BACK", "BrowserHome": "HOME",
+	"BrowserSearch": "SEARCH", "Power": "POWER", "WakeUp": "WAKEUP", "Sleep": "SLEEP",
+}
+
+func init() {
+	for i := 0; i < 10; i++ {
+		d := strconv.Itoa(i)
+		androidKeyCodes[d] = 7 + i
+		androidKeyCodes["NUMPAD_"+d] = 144 + i
+		w3cKeyCodes["Digit"+d] = d
+		w3cKeyCodes["Numpad"+d] = "NUMPAD_" + d
+	}
+	for c := 'A'; c <= 'Z'; c++ {
+		androidKeyCodes[string(c)] = 29 + int(c-'A')
+		w3cKeyCodes["Key"+string(c)] = string(c)
+	}
+	for i := 1; i <= 12; i++ {
+		f := "F" + strconv.Itoa(i)
+		androidKeyCodes[f] = 130 + i
+		w3cKeyCodes[f] = f
+	}
+}
+
+// KeyCode accept name like "HOME", "KEYCODE_HOME", "home" or a number like "3"
+func KeyCode(key string) (int, error) {
+	if code, err := strconv.Atoi(key); err == nil {
+		if code < 0 {
+			return 0, errors.Wrap(ErrUnknownKey, key)
+		}
+		return code, nil
+	}
+	name := strings.TrimPrefix(strings.ToUpper(key), "KEYCODE_")
+	if code, ok := androidKeyCodes[name]; ok {
+		return code, nil
+	}
+	return 0, errors.Wrap(ErrUnknownKey, key)
+}
+
+// KeyCodeFromW3C convert KeyboardEvent.code (eg: "KeyA", "ArrowUp") to android keycode
+func KeyCodeFromW3C(code string) (int, error) {
+	name, ok := w3cKeyCodes[code]
+	if !ok {
+		return 0, errors.Wrap(ErrUnknownKey, code)
+	}
+	return androidKeyCodes[name], nil
+}
+
+// splitInputText split text at every literal "%s", which "input text" always turns into a space
+// and has no way to escape, eg: "100%sure" is sent as "100%" and "sure"
+func splitInputText(text string) []string {
+	var parts []string
+	for {
+		idx := strings.Index(text, "%s")
+		if idx == -1 {
+			return append(parts, text)
+		}
+		parts = append(parts, text[:idx+1])
+		text = text[idx+1:]
+	}
+}
+
+// escapeInputText make text safe for "input text" running in device shell,
+// text must not contain "%s", see splitInputText
+func escapeInputText(text string) string {
+	text = strings.Replace(text, " ", "%s", -1)
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
+
+func isInputTextSafe(text string) bool {
+	for _, r := range text {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// KeyInputer send key events and text with "adb shell input"
+// "input keyevent" has no separated down and up, so Down only remember the time
+// and Up send a press, or a long press when it was held long enough.
+type KeyInputer struct {
+	TextMode    TextMode
+	mu          sync.Mutex
+	pressed     map[int]time.Time
+	adbKeyboard bool
+
+	run func(name string, args ...string) (string, error)
+	now func() time.Time
+
+	*adb.Device
+	errorMixin
+	safeMixin
+}
+
+func NewKeyInputer(device *adb.Device) *KeyInputer {
+	k := &KeyInputer{Device: device, now: time.Now}
+	k.run = func(name string, args ...string) (string, error) {
+		return AdbCheckOutput(k.Device, name, args...)
+	}
+	return k
+}
+
+func (k *KeyInputer) Start() error {
+	return k.safeDo(_ACTION_START, func() error {
+		k.resetError()
+		k.watchState(k.Wait)
+		out, _ := k.run("ime", "list", "-s")
+		k.mu.Lock()
+		k.adbKeyboard = strings.Contains(out, adbKeyboardIME)
+		k.pressed = make(map[int]time.Time)
+		k.mu.Unlock()
+		return nil
+	})
+}
+
+func (k *KeyInputer) Stop() error {
+	return k.safeDo(_ACTION_STOP, func() error {
+		k.doneNilError()
+		return k.Wait()
+	})
+}
+
+func (k *KeyInputer) keyevent(code int, longPress bool) error {
+	if !k.IsStarted() {
+		return ErrServiceNotStarted
+	}
+	args := []string{"keyevent"}
+	if longPress {
+		args = append(args, "--longpress")
+	}
+	_, err := k.run("input", append(args, strconv.Itoa(code))...)
+	return err
+}
+
+// PressCode send a key press by android keycode
+func (k *KeyInputer) PressCode(code int) error {
+	return k.keyevent(code, false)
+}
+
+// Press key by name or number, see KeyCode
+func (k *KeyInputer) Press(key string) error {
+	code, err := KeyCode(key)
+	if err != nil {
+		return err
+	}
+	return k.keyevent(code, false)
+}
+
+func (k *KeyInputer) LongPress(key string) error {
+	code, err := KeyCode(key)
+	if err != nil {
+		return err
+	}
+	return k.keyevent(code, true)
+}
+
+func (k *KeyInputer) DownCode(code int) error {
+	if !k.IsStarted() {
+		return ErrServiceNotStarted
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.pressed[code]; !ok {
+		k.pressed[code] = k.now()
+	}
+	return nil
+}
+
+func (k *KeyInputer) UpCode(code int) error {
+	k.mu.Lock()
+	downAt, ok := k.pressed[code]
+	delete(k.pressed, code)
+	k.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return k.keyevent(code, k.now().Sub(downAt) >= inputLongPressDuration)
+}
+
+func (k *KeyInputer) Down(key string) error {
+	code, err := KeyCode(key)
+	if err != nil {
+		return err
+	}
+	return k.DownCode(code)
+}
+
+func (k *KeyInputer) Up(key string) error {
+	code, err := KeyCode(key)
+	if err != nil {
+		return err
+	}
+	return k.UpCode(code)
+}
+
+// Type send text, newline and tab are sent as ENTER and TAB
+func (k *KeyInputer) Type(text string) error {
+	if !k.IsStarted() {
+		return ErrServiceNotStarted
+	}
+	k.mu.Lock()
+	mode, adbKeyboard := k.TextMode, k.adbKeyboard
+	k.mu.Unlock()
+	if mode == TEXT_AUTO {
+		mode = TEXT_INPUT
+		if !isInputTextSafe(strings.NewReplacer("\n", "", "\t", "").Replace(text)) {
+			mode = TEXT_ADB_KEYBOARD
+		}
+	}
+	if mode == TEXT_ADB_KEYBOARD {
+		if !adbKeyboard {
+			return ErrAdbKeyboardNotActive
+		}
+		_, err := k.run("am", "broadcast", "-a", "ADB_INPUT_B64",
+			"--es", "msg", base64.StdEncoding.EncodeToString([]byte(text)))
+		return err
+	}
+	var chunk []rune
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		s := string(chunk)
+		chunk = chunk[:0]
+		if !isInputTextSafe(s) {
+			return errors.New("input text only support printable ascii: " + strconv.Quote(s))
+		}
+		for _, part := range splitInputText(s) {
+			if _, err := k.run("input", "text", escapeInputText(part)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, r := range text {
+		code := 0
+		switch r {
+		case '\n':
+			code = androidKeyCodes["ENTER"]
+		case '\t':
+			code = androidKeyCodes["TAB"]
+		default:
+			chunk = append(chunk, r)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if err := k.PressCode(code); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
@@ -0,0 +1,84 @@
+package stf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyCode(t *testing.T) {
+	for key, code := range map[string]int{"HOME": 3, "KEYCODE_BACK": 4, "power": 26, "7": 7, "A": 29, "F12": 142, "NUMPAD_9": 153} {
+		c, err := KeyCode(key)
+		assert.NoError(t, err)
+		assert.Equal(t, code, c, key)
+	}
+	_, err := KeyCode("NOT_A_KEY")
+	assert.Error(t, err)
+
+	for key, code := range map[string]int{"KeyA": 29, "Digit0": 7, "Backspace": 67, "ArrowLeft": 21, "F1": 131, "Numpad5": 149} {
+		c, err := KeyCodeFromW3C(key)
+		assert.NoError(t, err)
+		assert.Equal(t, code, c, key)
+	}
+	_, err = KeyCodeFromW3C("Unidentified")
+	assert.Error(t, err)
+}
+
+func TestEscapeInputText(t *testing.T) {
+	assert.Equal(t, `'hello%sworld'`, escapeInputText("hello world"))
+	assert.Equal(t, `'it'\''s;$HOME'`, escapeInputText("it's;$HOME"))
+	assert.Equal(t, []string{"hello"}, splitInputText("hello"))
+	assert.Equal(t, []string{"100%", "sure"}, splitInputText("100%sure"))
+	assert.Equal(t, []string{"%", "s%", "s"}, splitInputText("%s%s"))
+}
+
+func newFakeKeyInputer(imes string) (*KeyInputer, *[]string) {
+	var cmds []string
+	k := NewKeyInputer(nil)
+	k.run = func(name string, args ...string) (string, error) {
+		if name == "ime" {
+			return imes, nil
+		}
+		cmds = append(cmds, name+" "+strings.Join(args, " "))
+		return "", nil
+	}
+	return k, &cmds
+}
+
+func TestKeyInputer(t *testing.T) {
+	k, cmds := newFakeKeyInputer("com.android.inputmethod.latin/.LatinIME")
+	assert.Equal(t, ErrServiceNotStarted, k.Press("HOME"))
+	assert.NoError(t, k.Start())
+	now := time.Unix(0, 0)
+	k.now = func() time.Time { return now }
+
+	assert.NoError(t, k.Press("HOME"))
+	assert.NoError(t, k.LongPress("POWER"))
+	assert.NoError(t, k.Down("BACK"))
+	assert.NoError(t, k.Up("BACK"))
+	assert.NoError(t, k.Down("VOLUME_UP"))
+	now = now.Add(time.Second)
+	assert.NoError(t, k.Up("VOLUME_UP"))
+	assert.NoError(t, k.Type("a b\nc%s"))
+	assert.Equal(t, ErrAdbKeyboardNotActive, k.Type("你好"))
+	assert.Equal(t, []string{
+		"input keyevent 3",
+		"input keyevent --longpress 26",
+		"input keyevent 4",
+		"input keyevent --longpress 24",
+		"input text 'a%sb'",
+		"input keyevent 66",
+		"input text 'c%'",
+		"input text 's'",
+	}, *cmds)
+	assert.NoError(t, k.Stop())
+}
+
+func TestKeyInputerAdbKeyboard(t *testing.T) {
+	k, cmds := newFakeKeyInputer("com.android.adbkeyboard/.AdbIME\n")
+	assert.NoError(t, k.Start())
+	assert.NoError(t, k.Type("你好"))
+	assert.Equal(t, []string{"am broadcast -a ADB_INPUT_B64 --es msg 5L2g5aW9"}, *cmds)
+}
@@ -0,0 +1,110 @@
+package stf
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+
+	adb "github.com/openatx/go-adb"
+)
+
+// Logger is implemented by *slog.Logger
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// logMixin carry the logger of a service, serial is resolved on the first message
+type logMixin struct {
+	lmu      sync.RWMutex
+	logger   Logger
+	service  string
+	serialOf func() (string, error) // nil when there is no device
+	describe func() string          // used when serial is not available
+	tagsOnce sync.Once
+	tags     []any
+}
+
+func (m *logMixin) initLogger(d *adb.Device, service string) {
+	m.service = service
+	if d != nil {
+		m.serialOf, m.describe = d.Serial, d.String
+	}
+}
+
+// logTags ask adb for serial only once, Serial may be a round trip to adb server
+func (m *logMixin) logTags() []any {
+	m.tagsOnce.Do(func() {
+		var serial string
+		if m.serialOf != nil {
+			var err error
+			if serial, err = m.serialOf(); err != nil {
+				serial = m.describe()
+			}
+		}
+		m.tags = []any{"serial", serial, "service", m.service}
+	})
+	return m.tags
+}
+
+// SetLogger set logger of the service, nil means slog.Default()
+func (m *logMixin) SetLogger(l Logger) {
+	m.lmu.Lock()
+	defer m.lmu.Unlock()
+	m.logger = l
+}
+
+// log return logger which tag every message with serial and service
+func (m *logMixin) log() Logger {
+	m.lmu.RLock()
+	l := m.logger
+	m.lmu.RUnlock()
+	if l == nil {
+		l = slog.Default()
+	}
+	return taggedLogger{l, m.logTags()}
+}
+
+type taggedLogger struct {
+	l    Logger
+	tags []any
+}
+
+func (t taggedLogger) with(args []any) []any {
+	return append(append([]any{}, t.tags...), args...)
+}
+
+func (t taggedLogger) Debug(msg string, args ...any) { t.l.Debug(msg, t.with(args)...) }
+func (t taggedLogger) Info(msg string, args ...any)  { t.l.Info(msg, t.with(args)...) }
+func (t taggedLogger) Warn(msg string, args ...any)  { t.l.Warn(msg, t.with(args)...) }
+func (t taggedLogger) Error(msg string, args ...any) { t.l.Error(msg, t.with(args)...) }
+
+// debugWriter log every line written to it at debug level, used for output of device binaries
+type debugWriter struct {
+	l   Logger
+	msg string
+	buf []byte
+}
+
+func (w *debugWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx == -1 {
+			break
+		}
+		w.l.Debug(w.msg, "line", string(bytes.TrimRight(w.buf[:idx], "\r")))
+		w.buf = w.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Flush log the last line without newline
+func (w *debugWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.l.Debug(w.msg, "line", string(w.buf))
+		w.buf = nil
+	}
+}
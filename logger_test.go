@@ -0,0 +1,83 @@
+package stf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordLogger keep messages as "LEVEL msg k=v ..."
+type recordLogger struct {
+	lines []string
+}
+
+func (r *recordLogger) log(level, msg string, args []any) {
+	line := level + " " + msg
+	for i := 0; i+1 < len(args); i += 2 {
+		line += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+	r.lines = append(r.lines, line)
+}
+
+func (r *recordLogger) Debug(msg string, args ...any) { r.log("DEBUG", msg, args) }
+func (r *recordLogger) Info(msg string, args ...any)  { r.log("INFO", msg, args) }
+func (r *recordLogger) Warn(msg string, args ...any)  { r.log("WARN", msg, args) }
+func (r *recordLogger) Error(msg string, args ...any) { r.log("ERROR", msg, args) }
+
+func TestLogMixin(t *testing.T) {
+	calls := 0
+	m := logMixin{service: "minitouch", serialOf: func() (string, error) {
+		calls++
+		return "emulator-5554", nil
+	}}
+	r := &recordLogger{}
+	m.SetLogger(r)
+	assert.Equal(t, 0, calls, "serial is resolved on the first message")
+	m.log().Warn("dial failed", "err", "refused")
+	m.log().Info("ready")
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{
+		"WARN dial failed serial=emulator-5554 service=minitouch err=refused",
+		"INFO ready serial=emulator-5554 service=minitouch",
+	}, r.lines)
+
+	// loggers of different services do not affect each other
+	other := logMixin{service: "rotation",
+		serialOf: func() (string, error) { return "", errors.New("device offline") },
+		describe: func() string { return "usb:1-1" }}
+	r2 := &recordLogger{}
+	other.SetLogger(r2)
+	other.log().Info("started")
+	assert.Len(t, r.lines, 2)
+	assert.Equal(t, []string{"INFO started serial=usb:1-1 service=rotation"}, r2.lines)
+}
+
+func TestSessionSetLogger(t *testing.T) {
+	touch := NewSTFTouch(nil, nil)
+	rotation := NewSTFRotation(nil, nil)
+	s := NewSessionWith(&fakeScreen{}, touch, rotation)
+	r := &recordLogger{}
+	s.SetLogger(r)
+	touch.log().Info("ready")
+	rotation.log().Info("ready")
+	assert.Equal(t, []string{
+		"INFO ready serial= service=minitouch",
+		"INFO ready serial= service=rotation",
+	}, r.lines)
+}
+
+func TestDebugWriter(t *testing.T) {
+	r := &recordLogger{}
+	w := &debugWriter{l: r, msg: "minitouch output"}
+	io.Copy(w, strings.NewReader("Note: device /dev/input/event1\r\nType B touch device\npartial"))
+	w.Flush()
+	assert.Equal(t, []string{
+		"DEBUG minitouch output line=Note: device /dev/input/event1",
+		"DEBUG minitouch output line=Type B touch device",
+		"DEBUG minitouch output line=partial",
+	}, r.lines)
+}
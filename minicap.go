@@ -3,7 +3,7 @@ package stf
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +11,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -42,64 +44,176 @@ type minicapInfo struct {
 	Rotation int     `json:"rotation"`
 }
 
+// CaptureOptions control how minicap produce frames, can be changed at runtime
+type CaptureOptions struct {
+	// MaxWidth and MaxHeight limit the virtual size in natural orientation,
+	// aspect ratio is preserved. 0 means no limit
+	MaxWidth, MaxHeight int
+	Quality             int  // JPEG quality 1-100 (-Q), 0 means minicap default
+	MaxFPS              int  // frame rate limit (-r), 0 means no limit
+	SkipFrames          bool // skip frames when busy (-S)
+	// ScreencapInterval is the minimum interval between frames
+	// when fall back to screencap
+	ScreencapInterval time.Duration
+}
+
+var DefaultCaptureOptions = CaptureOptions{
+	MaxWidth:          720,
+	MaxHeight:         720,
+	SkipFrames:        true,
+	ScreencapInterval: 500 * time.Millisecond,
+}
+
+func (o CaptureOptions) validate() error {
+	if o.MaxWidth < 0 || o.MaxHeight < 0 {
+		return errors.New("max size must not be negative")
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return errors.New("quality must be in range 0-100")
+	}
+	if o.MaxFPS < 0 {
+		return errors.New("max fps must not be negative")
+	}
+	if o.ScreencapInterval < 0 {
+		return errors.New("screencap interval must not be negative")
+	}
+	return nil
+}
+
+// projection return minicap -P value, eg: 1080x1920@405x720/0
+func (o CaptureOptions) projection(width, height, rotation int) string {
+	vw, vh := width, height
+	scale := 1.0
+	if o.MaxWidth > 0 && o.MaxWidth < vw {
+		scale = float64(o.MaxWidth) / float64(width)
+	}
+	if o.MaxHeight > 0 && o.MaxHeight < vh {
+		if s := float64(o.MaxHeight) / float64(height); s < scale {
+			scale = s
+		}
+	}
+	if scale < 1.0 {
+		vw, vh = int(float64(width)*scale+0.5), int(float64(height)*scale+0.5)
+	}
+	return fmt.Sprintf("%dx%d@%dx%d/%d", width, height, vw, vh, rotation)
+}
+
+func (o CaptureOptions) args(width, height, rotation int) []string {
+	args := []string{"-P", o.projection(width, height, rotation)}
+	if o.Quality > 0 {
+		args = append(args, "-Q", strconv.Itoa(o.Quality))
+	}
+	if o.MaxFPS > 0 {
+		args = append(args, "-r", strconv.Itoa(o.MaxFPS))
+	}
+	if o.SkipFrames {
+		args = append(args, "-S")
+	}
+	return args
+}
+
 type minicapDaemon struct {
-	width, height       int
-	maxWidth, maxHeight int
-	rotation            int
-	port                int
-	quitC               chan bool
-	rotationC           chan int
-	binaryPath          string
+	width, height int
+	rotation      int
+	port          int
+	quitC         chan bool
+	rotationC     chan int // keep only the latest rotation
+	restartC      chan bool
+	binaryPath    string
+	provider      BinaryProvider
+	optsMu        sync.Mutex
+	opts          CaptureOptions
+	connMu        sync.Mutex
+	capConn       io.Closer
 
 	*adb.Device
 	errorMixin
 	safeMixin
+	logMixin
 }
 
-func newMinicapDaemon(rotationC chan int, device *adb.Device) *minicapDaemon {
-	if rotationC == nil {
-		rotationC = make(chan int)
-	}
-	return &minicapDaemon{
-		rotationC: rotationC,
+func newMinicapDaemon(device *adb.Device, provider BinaryProvider) *minicapDaemon {
+	m := &minicapDaemon{
+		rotationC: make(chan int, 1),
+		restartC:  make(chan bool, 1),
 		Device:    device,
-		maxWidth:  720,
-		maxHeight: 720,
+		provider:  provider,
+		opts:      DefaultCaptureOptions,
 	}
+	m.initLogger(device, "minicap")
+	return m
 }
 
 func (m *minicapDaemon) Start() error {
+	return m.StartContext(context.Background())
+}
+
+func (m *minicapDaemon) StartContext(ctx context.Context) error {
 	return m.safeDo(_ACTION_START,
 		func() error {
-			m.resetError()
+			run := m.resetError()
+			m.watchState(m.Wait)
 			m.quitC = make(chan bool, 1)
+			select {
+			case <-m.restartC: // options set before start are already used
+			default:
+			}
+			select {
+			case r := <-m.rotationC:
+				m.rotation = r
+			default:
+			}
 			m.killMinicap()
-			if err := m.prepareSafe(); err != nil {
-				return errors.Wrap(err, "prepare minicap")
+			if err := m.prepareSafe(ctx); err != nil {
+				err = errors.Wrap(err, "prepare minicap")
+				run.done(err)
+				return err
 			}
-			go m.runScreenCaptureWithRotate() // TODO
+			go m.runScreenCaptureWithRotate(m.quitC, run)
 			return nil
 		})
 }
 
 func (m *minicapDaemon) Stop() error {
+	return stopWithTimeout(m.StopContext)
+}
+
+// StopContext close minicap connection when ctx is done before minicap quit
+func (m *minicapDaemon) StopContext(ctx context.Context) error {
 	return m.safeDo(_ACTION_STOP,
 		func() error {
 			m.quitC <- true
-			return m.Wait()
+			return waitContext(ctx, m.Wait, func(err error) {
+				m.setCapConn(nil)
+				m.doneError(errors.Wrap(err, "force stop minicap"))
+			})
 		})
 }
 
+// setCapConn close the previous connection of running minicap
+func (m *minicapDaemon) setCapConn(c io.Closer) {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	if m.capConn != nil {
+		m.capConn.Close()
+	}
+	m.capConn = c
+}
+
 // minicap may say resource is busy ..
-func (m *minicapDaemon) prepareSafe() (err error) {
+func (m *minicapDaemon) prepareSafe(ctx context.Context) (err error) {
 	n := 0
 	for {
-		err = m.prepare()
-		if err == nil || n >= 3 {
+		err = m.prepare(ctx)
+		if err == nil || n >= 3 || ctx.Err() != nil {
 			return
 		}
 		m.killMinicap()
-		time.Sleep(100 * time.Millisecond)
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 		n++
 	}
 }
@@ -107,8 +221,11 @@ func (m *minicapDaemon) prepareSafe() (err error) {
 // Check whether minicap is supported on the device
 // Check adb forward
 // For more information, see: https://github.com/openstf/minicap
-func (m *minicapDaemon) prepare() (err error) {
-	if err = m.pushFiles(); err != nil {
+func (m *minicapDaemon) prepare(ctx context.Context) (err error) {
+	if err = m.pushFiles(ctx); err != nil {
+		return
+	}
+	if err = ctx.Err(); err != nil {
 		return
 	}
 	switch {
@@ -183,79 +300,93 @@ func (m *minicapDaemon) takeScreenshot() (data []byte, err error) {
 	return
 }
 
-func (m *minicapDaemon) isRemoteExists(path string) bool {
-	_, err := m.Stat(path)
-	return err == nil
-}
-
-func (m *minicapDaemon) pushFiles() error {
-	props, err := m.Properties()
+func (m *minicapDaemon) pushFiles(ctx context.Context) error {
+	abi, sdk, err := deviceABIAndSDK(m.Device)
 	if err != nil {
 		return err
 	}
-	abi, ok := props["ro.product.cpu.abi"]
-	if !ok {
-		return errors.New("No ro.product.cpu.abi propery")
-	}
-	sdk, ok := props["ro.build.version.sdk"]
-	if !ok {
-		return errors.New("No ro.build.version.sdk propery")
-	}
-	for _, filename := range []string{"minicap.so", "minicap"} {
+	for _, filename := range []string{ARTIFACT_MINICAP_SO, ARTIFACT_MINICAP} {
 		dst := "/data/local/tmp/" + filename
-		if m.isRemoteExists(dst) {
-			continue
-		}
-		var urlStr string
 		var perms os.FileMode = 0644
-		baseUrl := "https://gohttp.nie.netease.com/openstf/vendor"
-		if filename == "minicap.so" {
-			urlStr = baseUrl + "/minicap/shared/android-" + sdk + "/" + abi + "/minicap.so"
-		} else {
+		if filename == ARTIFACT_MINICAP {
 			perms = 0755
-			urlStr = baseUrl + "/minicap/bin/" + abi + "/minicap"
 		}
-		err := PushFileFromHTTP(m.Device, dst, perms, urlStr)
+		err := installArtifact(ctx, m.log(), m.Device, m.provider, filename, abi, sdk, dst, perms)
 		if err != nil {
 			return err
 		}
 	}
-	err = PushFileFromHTTP(m.Device, "/data/local/tmp/slow-minicap", 0755, "https://gohttp.nie.netease.com/yosemite/slow-minicap/"+abi+"/slow-minicap")
+	err = installArtifact(ctx, m.log(), m.Device, m.provider, ARTIFACT_SLOW_MINICAP, abi, sdk, "/data/local/tmp/slow-minicap", 0755)
 	if err != nil {
 		return errors.Wrap(err, "push files")
 	}
 	return nil
 }
 
-// TODO(ssx): setQuality
-func (m *minicapDaemon) SetQuality(quality int) {
+func (m *minicapDaemon) Options() CaptureOptions {
+	m.optsMu.Lock()
+	defer m.optsMu.Unlock()
+	return m.opts
+}
+
+// SetOptions restart minicap if running, subscribers are kept
+func (m *minicapDaemon) SetOptions(opts CaptureOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	m.optsMu.Lock()
+	m.opts = opts
+	m.optsMu.Unlock()
+	select {
+	case m.restartC <- true:
+	default: // restart already pending
+	}
+	return nil
+}
+
+// SetMaxSize only change max virtual size, other options are kept
+func (m *minicapDaemon) SetMaxSize(width, height int) error {
+	opts := m.Options()
+	opts.MaxWidth, opts.MaxHeight = width, height
+	return m.SetOptions(opts)
+}
+
+// SetQuality is a shortcut of SetMaxSize with presets
+func (m *minicapDaemon) SetQuality(quality int) error {
 	switch quality {
 	case QUALITY_1080P:
-		m.maxHeight, m.maxHeight = 1080, 1080
+		return m.SetMaxSize(1080, 1080)
 	case QUALITY_720P:
-		m.maxHeight, m.maxHeight = 720, 720
+		return m.SetMaxSize(720, 720)
 	case QUALITY_480P:
-		m.maxHeight, m.maxHeight = 480, 480
+		return m.SetMaxSize(480, 480)
 	case QUALITY_240P:
-		m.maxHeight, m.maxHeight = 240, 240
+		return m.SetMaxSize(240, 240)
 	default:
-		return
+		return errors.New("unknown quality preset")
 	}
-	m.rotationC <- m.rotation // force restart minicap
 }
 
+// SetRotation never block or lose the value, minicap is restarted with the latest one
 func (m *minicapDaemon) SetRotation(r int) {
-	select {
-	case m.rotationC <- r:
-	case <-time.After(100 * time.Millisecond):
+	for {
+		select {
+		case m.rotationC <- r:
+			return
+		default:
+		}
+		select { // replace the rotation not used yet
+		case <-m.rotationC:
+		default:
+		}
 	}
 }
 
-func (m *minicapDaemon) runScreenCaptureWithRotate() {
+func (m *minicapDaemon) runScreenCaptureWithRotate(quitC chan bool, run *errorRun) {
 	m.killMinicap()
 	var err error
 	defer func() {
-		m.doneError(errors.Wrap(err, "minicap"))
+		run.done(errors.Wrap(err, "minicap"))
 	}()
 	errC := GoFunc(m.runScreenCapture)
 	var needRestart bool
@@ -268,11 +399,17 @@ func (m *minicapDaemon) runScreenCaptureWithRotate() {
 			needRestart = false
 			err = nil
 			errC = GoFunc(m.runScreenCapture)
+			m.recovered()
 		case r := <-m.rotationC:
 			needRestart = true
 			m.rotation = r
+			m.restarting(fmt.Errorf("rotation changed to %d", r))
 			m.killMinicap()
-		case <-m.quitC:
+		case <-m.restartC:
+			needRestart = true
+			m.restarting(errors.New("options changed"))
+			m.killMinicap()
+		case <-quitC:
 			m.killMinicap()
 			return
 		}
@@ -280,11 +417,12 @@ func (m *minicapDaemon) runScreenCaptureWithRotate() {
 }
 
 func (m *minicapDaemon) runScreenCapture() (err error) {
-	param := fmt.Sprintf("%dx%d@%dx%d/%d", m.width, m.height, m.maxWidth, m.maxHeight, m.rotation)
-	c, err := m.OpenCommand("LD_LIBRARY_PATH=/data/local/tmp", m.binaryPath, "-P", param, "-S")
+	args := append([]string{m.binaryPath}, m.Options().args(m.width, m.height, m.rotation)...)
+	c, err := m.OpenCommand("LD_LIBRARY_PATH=/data/local/tmp", args...)
 	if err != nil {
 		return
 	}
+	m.setCapConn(c)
 	defer c.Close()
 	buf := bufio.NewReader(c)
 
@@ -293,11 +431,13 @@ func (m *minicapDaemon) runScreenCapture() (err error) {
 	// PID: 9355
 	// INFO: Using projection 720x1280@720x1280/0
 	// INFO: (jni/minicap/JpgEncoder.cpp:64) Allocating 2766852 bytes for JPG encoder
+	logger := m.log()
 	for {
 		line, _, err := buf.ReadLine()
 		if err != nil {
 			return err
 		}
+		logger.Debug("minicap output", "line", string(line))
 		if strings.HasPrefix(string(line), "WARNING") {
 			continue
 		}
@@ -308,10 +448,12 @@ func (m *minicapDaemon) runScreenCapture() (err error) {
 		break
 	}
 	for {
-		_, _, err = buf.ReadLine()
+		var line []byte
+		line, _, err = buf.ReadLine()
 		if err != nil {
 			break
 		}
+		logger.Debug("minicap output", "line", string(line))
 	}
 	return errors.New("minicap quit")
 }
@@ -354,8 +496,8 @@ type jpgTcpSucker struct {
 	port        int
 	conn        net.Conn
 	quitC       chan bool
-	C           chan []byte
 	forwardSpec adb.ForwardSpec
+	hub         *frameHub
 
 	errorMixin
 	safeMixin
@@ -365,13 +507,14 @@ type jpgTcpSucker struct {
 func (s *jpgTcpSucker) Start() error {
 	return s.safeDo(_ACTION_START, func() error {
 		s.resetError()
+		s.watchState(s.Wait)
 		var err error
-		s.C = make(chan []byte, 3)
 		s.quitC = make(chan bool, 1)
 		s.port, err = s.ForwardToFreePort(s.forwardSpec)
 		if err != nil {
 			return err
 		}
+		s.hub.open()
 		go s.keepReadFromTcp()
 		return nil
 	})
@@ -387,36 +530,25 @@ func (s *jpgTcpSucker) Stop() error {
 	})
 }
 
-type errorBinaryReader struct {
-	rd  io.Reader
-	err error
-}
-
-func (r *errorBinaryReader) ReadInto(datas ...interface{}) error {
-	if r.err != nil {
-		return r.err
-	}
-	for _, data := range datas {
-		r.err = binary.Read(r.rd, binary.LittleEndian, data)
-		if r.err != nil {
-			return r.err
-		}
-	}
-	return nil
-}
-
 // TODO(ssx): Do not add retry for now
 func (s *jpgTcpSucker) keepReadFromTcp() (err error) {
 	defer func() {
+		s.hub.close()
 		s.doneError(errors.Wrap(err, "readFromTcp"))
 	}()
 	leftRetry := 10
 	for {
+		lastSeq := s.hub.lastSeq()
 		select {
 		case err = <-GoFunc(s.readFromTcp):
 		case <-s.quitC:
 			return nil
 		}
+		// minicap restarted because of rotation or options change
+		if s.hub.lastSeq() != lastSeq {
+			leftRetry = 10
+		}
+		s.restarting(err)
 		select {
 		case <-time.After(500 * time.Millisecond):
 		case <-s.quitC:
@@ -438,73 +570,108 @@ func (s *jpgTcpSucker) readFromTcp() (err error) {
 	s.conn = conn
 	defer conn.Close()
 
-	var pid, rw, rh, vw, vh uint32
-	var version, unused, orientation, quirkFlag uint8
-
-	rd := bufio.NewReader(conn)
-	binRd := errorBinaryReader{rd: rd}
-	err = binRd.ReadInto(&version, &unused, &pid, &rw, &rh, &vw, &vh, &orientation, &quirkFlag)
-	if err != nil {
+	mrd := NewMinicapReader(conn)
+	if _, err = mrd.ReadBanner(); err != nil {
 		return err
 	}
-
+	s.recovered()
 	for {
-		var size uint32
-		if err = binRd.ReadInto(&size); err != nil {
-			break
-		}
-
-		lr := &io.LimitedReader{rd, int64(size)}
-		buf := bytes.NewBuffer(nil)
-		_, err = io.Copy(buf, lr)
+		f, err := mrd.ReadFrame()
 		if err != nil {
-			break
-		}
-		if string(buf.Bytes()[:2]) != "\xff\xd8" {
-			err = errors.New("jpeg format error, not starts with 0xff,0xd8")
-			break
-		}
-		select {
-		case s.C <- buf.Bytes(): // Maybe should use buffer instead
-		default:
-			// image should not wait or it will stuck here
+			return err
 		}
+		s.hub.pub(f)
 	}
-	return err
 }
 
+var (
+	_ ScreenReader    = (*STFCapturer)(nil)
+	_ FrameSource     = (*STFCapturer)(nil)
+	_ ContextServicer = (*STFCapturer)(nil)
+)
+
+// STFCapturer capture screen with minicap,
+// fall back to screencap when minicap is not supported on the device
 type STFCapturer struct {
 	*minicapDaemon
 	*jpgTcpSucker
+	*frameHub
+	screencap    *ScreencapCapturer
+	useScreencap int32 // atomic, 1 when screencap fallback is in use
+	unfollow     []func()
+
+	safeMixin
 }
 
-func NewSTFCapturer(device *adb.Device) *STFCapturer {
+// NewSTFCapturer create capturer, provider can be nil to use DefaultBinaryProvider
+func NewSTFCapturer(device *adb.Device, provider BinaryProvider) *STFCapturer {
+	hub := newFrameHub()
 	return &STFCapturer{
-		minicapDaemon: newMinicapDaemon(nil, device),
-		jpgTcpSucker:  &jpgTcpSucker{Device: device},
+		minicapDaemon: newMinicapDaemon(device, provider),
+		jpgTcpSucker:  &jpgTcpSucker{Device: device, hub: hub},
+		frameHub:      hub,
+		screencap:     newScreencapCapturer(device, hub),
 	}
 }
 
 func (s *STFCapturer) Start() error {
-	err := s.minicapDaemon.Start()
-	if err != nil {
-		return err
-	}
-	if s.minicapDaemon.binaryPath == "/data/local/tmp/slow-minicap" {
-		s.jpgTcpSucker.forwardSpec = adb.ForwardSpec{adb.FProtocolTcp, "2016"}
-	} else {
-		s.jpgTcpSucker.forwardSpec = adb.ForwardSpec{adb.FProtocolAbstract, "minicap"}
-	}
-	return s.jpgTcpSucker.Start()
+	return s.StartContext(context.Background())
+}
+
+// StartContext do not fall back to screencap when ctx is cancelled
+func (s *STFCapturer) StartContext(ctx context.Context) error {
+	return s.safeDo(_ACTION_START, func() error {
+		s.watchState(s.Wait)
+		err := s.minicapDaemon.StartContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			atomic.StoreInt32(&s.useScreencap, 1)
+			s.screencap.SetInterval(s.Options().ScreencapInterval)
+			if er := s.screencap.Start(); er != nil {
+				return wrapMultiError(err, er)
+			}
+			s.unfollow = []func(){s.follow(s.screencap)}
+			return nil
+		}
+		atomic.StoreInt32(&s.useScreencap, 0)
+		if s.minicapDaemon.binaryPath == "/data/local/tmp/slow-minicap" {
+			s.jpgTcpSucker.forwardSpec = adb.ForwardSpec{adb.FProtocolTcp, "2016"}
+		} else {
+			s.jpgTcpSucker.forwardSpec = adb.ForwardSpec{adb.FProtocolAbstract, "minicap"}
+		}
+		if err = s.jpgTcpSucker.Start(); err != nil {
+			s.minicapDaemon.Stop()
+			return err
+		}
+		s.unfollow = []func(){s.follow(s.minicapDaemon), s.follow(s.jpgTcpSucker)}
+		return nil
+	})
 }
 
 func (s *STFCapturer) Stop() error {
-	return wrapMultiError(
-		s.minicapDaemon.Stop(),
-		s.jpgTcpSucker.Stop())
+	return stopWithTimeout(s.StopContext)
+}
+
+func (s *STFCapturer) StopContext(ctx context.Context) error {
+	return s.safeDo(_ACTION_STOP, func() error {
+		for _, unfollow := range s.unfollow {
+			unfollow()
+		}
+		if s.UsingScreencap() {
+			return s.screencap.Stop()
+		}
+		return wrapMultiError(
+			s.minicapDaemon.StopContext(ctx),
+			s.jpgTcpSucker.Stop())
+	})
 }
 
 func (s *STFCapturer) Wait() error {
+	if s.UsingScreencap() {
+		return s.screencap.Wait()
+	}
 	select {
 	case err := <-GoFunc(s.minicapDaemon.Wait):
 		return err
@@ -515,3 +682,24 @@ func (s *STFCapturer) Wait() error {
 	// 	s.minicapDaemon.Wait(),
 	// 	s.jpgTcpSucker.Wait())
 }
+
+// SetRotation restart minicap with new rotation, screencap always follow device rotation
+func (s *STFCapturer) SetRotation(r int) {
+	s.screencap.SetRotation(r)
+	if !s.UsingScreencap() {
+		s.minicapDaemon.SetRotation(r)
+	}
+}
+
+// UsingScreencap report whether the screencap fallback is in use
+func (s *STFCapturer) UsingScreencap() bool {
+	return atomic.LoadInt32(&s.useScreencap) == 1
+}
+
+func (s *STFCapturer) SetOptions(opts CaptureOptions) error {
+	if err := s.minicapDaemon.SetOptions(opts); err != nil {
+		return err
+	}
+	s.screencap.SetInterval(opts.ScreencapInterval)
+	return nil
+}
@@ -1,10 +1,8 @@
 package stf
 
 import (
-	"bytes"
-	"image/jpeg"
+	"sync/atomic"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,15 +13,42 @@ func TestSTFCapturer(t *testing.T) {
 	assert.NoError(t, err)
 
 	for i := 0; i < 20; i++ {
-		select {
-		case jpgData := <-cap.C:
-			_, err := jpeg.Decode(bytes.NewReader(jpgData))
-			assert.NoError(t, err)
-		case <-time.After(time.Second * 2):
-			t.Error("no image captured")
-		}
+		_, err := cap.NextImage()
+		assert.NoError(t, err)
 	}
+	_, err = cap.LastImage()
+	assert.NoError(t, err)
 
 	err = cap.Stop()
 	assert.NoError(t, err)
 }
+
+func TestCaptureOptions(t *testing.T) {
+	opts := DefaultCaptureOptions
+	assert.Equal(t, "1080x1920@405x720/0", opts.projection(1080, 1920, 0))
+	opts.MaxWidth, opts.MaxHeight = 540, 0
+	assert.Equal(t, "1080x1920@540x960/90", opts.projection(1080, 1920, 90))
+	opts.MaxWidth = 0
+	assert.Equal(t, "1080x1920@1080x1920/0", opts.projection(1080, 1920, 0))
+
+	opts = CaptureOptions{MaxWidth: 720, MaxHeight: 720, Quality: 80, MaxFPS: 15}
+	assert.Equal(t, []string{"-P", "720x1280@405x720/0", "-Q", "80", "-r", "15"}, opts.args(720, 1280, 0))
+	assert.NoError(t, opts.validate())
+	opts.Quality = 101
+	assert.Error(t, opts.validate())
+}
+
+func TestSTFCapturerScreencapRotation(t *testing.T) {
+	cap := NewSTFCapturer(nil, nil)
+	atomic.StoreInt32(&cap.useScreencap, 1)
+	assert.True(t, cap.UsingScreencap())
+	cap.SetRotation(90)
+	assert.Equal(t, int32(90), atomic.LoadInt32(&cap.screencap.rotation))
+}
+
+func TestMinicapSetRotation(t *testing.T) {
+	m := newMinicapDaemon(nil, nil)
+	m.SetRotation(90) // minicap loop is busy
+	m.SetRotation(180)
+	assert.Equal(t, 180, <-m.rotationC, "latest rotation is kept")
+}
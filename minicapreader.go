@@ -0,0 +1,138 @@
+package stf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// minicap banner quirk bits
+// For more information, see: https://github.com/openstf/minicap#usage
+const (
+	QUIRK_DUMB           = 1
+	QUIRK_ALWAYS_UPRIGHT = 2
+	QUIRK_TEAR           = 4
+)
+
+const minicapBannerSize = 24
+
+// MinicapBanner is the global header sent by minicap once a client connected
+type MinicapBanner struct {
+	Version       int
+	Length        int
+	Pid           int
+	RealWidth     int
+	RealHeight    int
+	VirtualWidth  int
+	VirtualHeight int
+	Orientation   int // 0, 90, 180, 270
+	Quirks        uint8
+
+	QuirkDumb          bool // frames only sent when screen changed
+	QuirkAlwaysUpright bool // frames not rotated with device
+	QuirkTear          bool // frames may tear
+}
+
+type errorBinaryReader struct {
+	rd  io.Reader
+	err error
+}
+
+func (r *errorBinaryReader) ReadInto(datas ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	for _, data := range datas {
+		r.err = binary.Read(r.rd, binary.LittleEndian, data)
+		if r.err != nil {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// MinicapReader parse minicap protocol from any io.Reader, eg: tcp connection, pipe or file
+type MinicapReader struct {
+	binRd  errorBinaryReader
+	banner *MinicapBanner
+	seq    uint64
+}
+
+func NewMinicapReader(rd io.Reader) *MinicapReader {
+	return &MinicapReader{
+		binRd: errorBinaryReader{rd: bufio.NewReader(rd)},
+	}
+}
+
+// ReadBanner read banner only once, later calls return the same banner
+func (r *MinicapReader) ReadBanner() (*MinicapBanner, error) {
+	if r.banner != nil {
+		return r.banner, nil
+	}
+	var pid, rw, rh, vw, vh uint32
+	var version, length, orientation, quirks uint8
+	err := r.binRd.ReadInto(&version, &length, &pid, &rw, &rh, &vw, &vh, &orientation, &quirks)
+	if err != nil {
+		return nil, errors.Wrap(err, "read minicap banner")
+	}
+	if length < minicapBannerSize {
+		return nil, errors.Errorf("invalid minicap banner length %d", length)
+	}
+	// skip fields added by newer minicap
+	if extra := int64(length) - minicapBannerSize; extra > 0 {
+		if _, err := io.CopyN(ioutil.Discard, r.binRd.rd, extra); err != nil {
+			return nil, errors.Wrap(err, "read minicap banner")
+		}
+	}
+	r.banner = &MinicapBanner{
+		Version:            int(version),
+		Length:             int(length),
+		Pid:                int(pid),
+		RealWidth:          int(rw),
+		RealHeight:         int(rh),
+		VirtualWidth:       int(vw),
+		VirtualHeight:      int(vh),
+		Orientation:        int(orientation) * 90,
+		Quirks:             quirks,
+		QuirkDumb:          quirks&QUIRK_DUMB != 0,
+		QuirkAlwaysUpright: quirks&QUIRK_ALWAYS_UPRIGHT != 0,
+		QuirkTear:          quirks&QUIRK_TEAR != 0,
+	}
+	return r.banner, nil
+}
+
+// ReadFrame read the next jpeg frame, banner is read first if needed
+func (r *MinicapReader) ReadFrame() (*Frame, error) {
+	banner, err := r.ReadBanner()
+	if err != nil {
+		return nil, err
+	}
+	var size uint32
+	if err := r.binRd.ReadInto(&size); err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := io.CopyN(buf, r.binRd.rd, int64(size)); err != nil {
+		return nil, errors.Wrap(err, "read minicap frame")
+	}
+	if size < 2 || string(buf.Bytes()[:2]) != "\xff\xd8" {
+		return nil, errors.New("jpeg format error, not starts with 0xff,0xd8")
+	}
+	r.seq++
+	return &Frame{
+		Seq:           r.seq,
+		Time:          time.Now(),
+		Size:          buf.Len(),
+		Rotation:      banner.Orientation,
+		RealWidth:     banner.RealWidth,
+		RealHeight:    banner.RealHeight,
+		VirtualWidth:  banner.VirtualWidth,
+		VirtualHeight: banner.VirtualHeight,
+		Data:          buf.Bytes(),
+	}, nil
+}
@@ -0,0 +1,63 @@
+package stf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMinicapStream(w io.Writer, frames ...[]byte) {
+	binary.Write(w, binary.LittleEndian, []uint8{1, 24})
+	binary.Write(w, binary.LittleEndian, []uint32{1234, 1080, 1920, 720, 1280})
+	binary.Write(w, binary.LittleEndian, []uint8{1, QUIRK_DUMB | QUIRK_TEAR})
+	for _, data := range frames {
+		binary.Write(w, binary.LittleEndian, uint32(len(data)))
+		w.Write(data)
+	}
+}
+
+func TestMinicapReader(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	writeMinicapStream(buf, []byte("\xff\xd8first"), []byte("\xff\xd8second"), []byte("bad"))
+
+	rd := NewMinicapReader(buf)
+	banner, err := rd.ReadBanner()
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, banner.Pid)
+	assert.Equal(t, 1080, banner.RealWidth)
+	assert.Equal(t, 1280, banner.VirtualHeight)
+	assert.Equal(t, 90, banner.Orientation)
+	assert.True(t, banner.QuirkDumb)
+	assert.False(t, banner.QuirkAlwaysUpright)
+	assert.True(t, banner.QuirkTear)
+
+	f, err := rd.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), f.Seq)
+	assert.Equal(t, "\xff\xd8first", string(f.Data))
+	assert.Equal(t, 90, f.Rotation)
+	assert.Equal(t, 720, f.VirtualWidth)
+
+	f, err = rd.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), f.Seq)
+	assert.Equal(t, 8, f.Size)
+
+	_, err = rd.ReadFrame()
+	assert.Error(t, err)
+}
+
+func TestMinicapReaderTruncated(t *testing.T) {
+	_, err := NewMinicapReader(bytes.NewReader([]byte{1, 24, 0})).ReadBanner()
+	assert.Error(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	writeMinicapStream(buf)
+	binary.Write(buf, binary.LittleEndian, uint32(100))
+	buf.WriteString("\xff\xd8short")
+	_, err = NewMinicapReader(buf).ReadFrame()
+	assert.Error(t, err)
+}
@@ -2,13 +2,13 @@ package stf
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"net"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,71 +16,161 @@ import (
 	"github.com/pkg/errors"
 )
 
-type TouchAction int
+// DefaultTouchPressure is used when TouchEvent.Pressure is 0
+const DefaultTouchPressure = 0.5
 
-const (
-	TOUCH_DOWN = TouchAction(iota)
-	TOUCH_MOVE
-	TOUCH_UP
+// TouchInfo is parsed from minitouch banner
+//
+//	v 1
+//	^ 10 1079 1919 255
+//	$ 12345
+type TouchInfo struct {
+	Version     int
+	MaxContacts int
+	MaxX        int
+	MaxY        int
+	MaxPressure int
+	Pid         int
+}
+
+var (
+	ErrTouchNotReady = errors.New("minitouch not ready")
+	ErrTouchClosed   = errors.New("minitouch connection closed")
+)
+
+var (
+	_ Toucher         = (*STFTouch)(nil)
+	_ ScriptRunner    = (*STFTouch)(nil)
+	_ CapableToucher  = (*STFTouch)(nil)
+	_ ContextServicer = (*STFTouch)(nil)
 )
 
+type touchRequest struct {
+	cmds string
+	errC chan error
+}
+
+// touchRun is created by every start, goroutines left by a forced stop only use their own
+type touchRun struct {
+	cmdC     chan touchRequest
+	deadC    chan bool // closed when commands can not be sent any more
+	readyC   chan bool // closed when minitouch connected
+	binDoneC chan bool // closed when minitouch process quit
+	binConn  io.Closer // shell running minitouch, guarded by stateMu
+	*errorRun
+}
+
 type STFTouch struct {
-	cmdC       chan string
-	conn       net.Conn
-	maxX, maxY int
-	rotation   int
+	active   *touchRun
+	cancel   context.CancelFunc // abort dialing
+	stateMu  sync.RWMutex
+	info     TouchInfo
+	rotation int
+	provider BinaryProvider
 
 	*adb.Device
 	errorMixin
 	safeMixin
+	logMixin
 }
 
-func NewSTFTouch(device *adb.Device) *STFTouch {
-	return &STFTouch{
-		Device: device,
-		cmdC:   make(chan string, 0),
+// NewSTFTouch create touch service, provider can be nil to use DefaultBinaryProvider
+func NewSTFTouch(device *adb.Device, provider BinaryProvider) *STFTouch {
+	s := &STFTouch{
+		Device:   device,
+		provider: provider,
 	}
+	s.initLogger(device, "minitouch")
+	return s
 }
 
 func (s *STFTouch) Start() error {
+	return s.StartContext(context.Background())
+}
+
+func (s *STFTouch) StartContext(ctx context.Context) error {
 	return s.safeDo(_ACTION_START, func() error {
-		s.resetError()
-		if err := s.prepare(); err != nil {
+		run := &touchRun{
+			cmdC:     make(chan touchRequest),
+			deadC:    make(chan bool),
+			readyC:   make(chan bool),
+			binDoneC: make(chan bool),
+			errorRun: s.resetError(),
+		}
+		s.watchState(s.Wait)
+		if err := s.prepare(ctx); err != nil {
+			run.done(err)
 			return err
 		}
-		go s.runBinary()
+		runCtx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		s.stateMu.Lock()
+		s.active = run
+		s.stateMu.Unlock()
+		go s.runBinary(run)
 		go func() {
-			time.Sleep(time.Second)
-			s.drainCmd()
+			select {
+			case <-time.After(time.Second):
+			case <-runCtx.Done():
+			}
+			s.drainCmd(runCtx, run)
 		}()
 		return nil
 	})
 }
 
 func (s *STFTouch) Stop() error {
+	return stopWithTimeout(s.StopContext)
+}
+
+// StopContext close minitouch shell when ctx is done before minitouch quit
+func (s *STFTouch) StopContext(ctx context.Context) error {
 	return s.safeDo(_ACTION_STOP, func() error {
-		s.killProc("minitouch", syscall.SIGKILL)
-		return s.Wait()
+		s.cancel()
+		return waitContext(ctx, func() error {
+			s.killProc("minitouch", syscall.SIGKILL)
+			return s.Wait()
+		}, func(err error) {
+			run := s.activeRun()
+			s.closeBinConn(run)
+			run.done(errors.Wrap(err, "force stop minitouch"))
+		})
 	})
 }
 
 func (s *STFTouch) SetRotation(r int) {
+	s.stateMu.Lock()
 	s.rotation = r
+	s.stateMu.Unlock()
+}
+
+func (s *STFTouch) Rotation() int {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	return s.rotation
+}
+
+// Size return touch coordinate range (MaxX, MaxY from minitouch banner) of current rotation,
+// it is not always the screen size in pixels. 0 if minitouch is not ready
+func (s *STFTouch) Size() (width, height int) {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	return int(s.width()), int(s.height())
 }
 
 func (s *STFTouch) width() float64 {
 	if s.rotation == 0 || s.rotation == 180 {
-		return float64(s.maxX)
+		return float64(s.info.MaxX)
 	} else {
-		return float64(s.maxY)
+		return float64(s.info.MaxY)
 	}
 }
 
 func (s *STFTouch) height() float64 {
 	if s.rotation == 0 || s.rotation == 180 {
-		return float64(s.maxY)
+		return float64(s.info.MaxY)
 	} else {
-		return float64(s.maxX)
+		return float64(s.info.MaxX)
 	}
 }
 
@@ -127,6 +217,7 @@ func (s *STFTouch) height() float64 {
  * |--------------|------|---------|---------|---------|
  */
 
+// coords must be called with stateMu held
 func (s *STFTouch) coords(xP, yP float64) (x, y int) {
 	switch s.rotation {
 	case 90:
@@ -136,70 +227,259 @@ func (s *STFTouch) coords(xP, yP float64) (x, y int) {
 	case 270:
 		xP, yP = yP, 1-xP
 	}
-	w, h := float64(s.maxX), float64(s.maxY)
+	w, h := float64(s.info.MaxX), float64(s.info.MaxY)
 	return int(w * xP), int(h * yP)
 }
 
-func (s *STFTouch) Down(index int, xP, yP float64) {
-	posX, posY := s.coords(xP, yP)
-	s.cmdC <- fmt.Sprintf("d %v %v %v 50", index, posX, posY)
+// pixel coordinates of current rotation to percentage
+func (s *STFTouch) percent(x, y int) (xP, yP float64, err error) {
+	width, height := s.Size()
+	if width == 0 || height == 0 {
+		return 0, 0, ErrTouchNotReady
+	}
+	return float64(x) / float64(width), float64(y) / float64(height), nil
+}
+
+// Info return minitouch banner, error if minitouch is not connected yet
+func (s *STFTouch) Info() (TouchInfo, error) {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	if s.info.MaxX == 0 {
+		return s.info, ErrTouchNotReady
+	}
+	return s.info, nil
+}
+
+func (s *STFTouch) command(e TouchEvent) (string, error) {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	if e.Index < 0 || (s.info.MaxContacts > 0 && e.Index >= s.info.MaxContacts) {
+		return "", fmt.Errorf("contact index %d out of range [0, %d)", e.Index, s.info.MaxContacts)
+	}
+	if e.Action == TOUCH_UP {
+		return fmt.Sprintf("u %d", e.Index), nil
+	}
+	pressure := e.Pressure
+	if pressure == 0 {
+		pressure = DefaultTouchPressure
+	}
+	if pressure < 0 || pressure > 1 {
+		return "", fmt.Errorf("pressure %v out of range [0, 1]", e.Pressure)
+	}
+	posX, posY := s.coords(e.X, e.Y)
+	return fmt.Sprintf("%s %d %d %d %d", e.Action, e.Index, posX, posY,
+		int(pressure*float64(s.info.MaxPressure)+0.5)), nil
 }
 
-func (s *STFTouch) Move(index int, xP, yP float64) {
-	posX, posY := s.coords(xP, yP)
-	s.cmdC <- fmt.Sprintf("m %v %v %v 50", index, posX, posY)
+// Down xP, yP is percentage of screen width and height, range [0, 1)
+func (s *STFTouch) Down(index int, xP, yP float64) error {
+	return s.Do(TouchEvent{Action: TOUCH_DOWN, Index: index, X: xP, Y: yP})
 }
 
-func (s *STFTouch) Up(index int) {
-	s.cmdC <- fmt.Sprintf("u %d", index)
+func (s *STFTouch) Move(index int, xP, yP float64) error {
+	return s.Do(TouchEvent{Action: TOUCH_MOVE, Index: index, X: xP, Y: yP})
 }
 
-func (s *STFTouch) prepare() error {
-	dst := "/data/local/tmp/minitouch"
-	if AdbFileExists(s.Device, dst) {
+func (s *STFTouch) Up(index int) error {
+	return s.Do(TouchEvent{Action: TOUCH_UP, Index: index})
+}
+
+// DownPx x, y is pixel position of current rotation
+func (s *STFTouch) DownPx(index int, x, y int) error {
+	xP, yP, err := s.percent(x, y)
+	if err != nil {
+		return err
+	}
+	return s.Down(index, xP, yP)
+}
+
+func (s *STFTouch) MovePx(index int, x, y int) error {
+	xP, yP, err := s.percent(x, y)
+	if err != nil {
+		return err
+	}
+	return s.Move(index, xP, yP)
+}
+
+// Do send all events then commit once, so multiple contacts act at the same time
+func (s *STFTouch) Do(events ...TouchEvent) error {
+	if len(events) == 0 {
 		return nil
 	}
-	props, err := s.Properties()
+	lines := make([]string, 0, len(events)+1)
+	for _, e := range events {
+		line, err := s.command(e)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "c")
+	return s.send(strings.Join(lines, "\n"))
+}
+
+// RunScript send the whole script in one write, and block until all waits in script passed
+func (s *STFTouch) RunScript(script *TouchScript) error {
+	cmds, err := s.scriptCommands(script)
 	if err != nil {
 		return err
 	}
-	abi, ok := props["ro.product.cpu.abi"]
-	if !ok {
-		return errors.New("No ro.product.cpu.abi propery")
+	if err := s.send(cmds); err != nil {
+		return err
+	}
+	time.Sleep(script.Duration())
+	return nil
+}
+
+func (s *STFTouch) scriptCommands(script *TouchScript) (string, error) {
+	lines := make([]string, 0, len(script.ops))
+	for _, op := range script.ops {
+		switch op.kind {
+		case _SCRIPT_EVENT:
+			line, err := s.command(op.event)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, line)
+		case _SCRIPT_COMMIT:
+			lines = append(lines, "c")
+		case _SCRIPT_WAIT:
+			lines = append(lines, fmt.Sprintf("w %d", op.wait/time.Millisecond))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// WaitReady block until minitouch is connected.
+// It is useful to find out early that minitouch can not work on the device.
+func (s *STFTouch) WaitReady(timeout time.Duration) error {
+	if !s.IsStarted() {
+		return ErrServiceNotStarted
+	}
+	run := s.activeRun()
+	select {
+	case <-run.readyC:
+		return nil
+	case <-run.deadC:
+		if err := run.wait(); err != nil {
+			return err
+		}
+		return ErrTouchClosed
+	case <-time.After(timeout):
+		return errors.New("wait minitouch ready timeout")
+	}
+}
+
+func (s *STFTouch) Capabilities() TouchCapabilities {
+	info, err := s.Info()
+	if err != nil || info.MaxContacts == 0 { // banner not received yet
+		return TouchCapabilities{}
+	}
+	return TouchCapabilities{
+		MultiTouch:  info.MaxContacts > 1,
+		MaxContacts: info.MaxContacts,
+		Pressure:    info.MaxPressure > 0,
+		Move:        true,
+	}
+}
+
+// SendRaw send minitouch commands as is, eg: "d 0 10 10 50\nc\n"
+// Coordinates are not rotated, and nothing is committed unless "c" is given
+func (s *STFTouch) SendRaw(cmds string) error {
+	return s.send(cmds)
+}
+
+// send block until commands written to minitouch, or minitouch is dead
+func (s *STFTouch) send(cmds string) error {
+	if !s.IsStarted() {
+		return ErrServiceNotStarted
+	}
+	run := s.activeRun()
+	req := touchRequest{cmds: cmds, errC: make(chan error, 1)}
+	select {
+	case run.cmdC <- req:
+		return <-req.errC
+	case <-run.deadC:
+		return ErrTouchClosed
 	}
-	urlStr := "https://github.com/openstf/stf/raw/master/vendor/minitouch/" + abi + "/minitouch"
-	return PushFileFromHTTP(s.Device, dst, 0755, urlStr)
 }
 
-func (s *STFTouch) runBinary() (err error) {
-	defer s.doneError(err)
+func (s *STFTouch) activeRun() *touchRun {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	return s.active
+}
+
+func (s *STFTouch) prepare(ctx context.Context) error {
+	dst := "/data/local/tmp/minitouch"
+	abi, sdk, err := deviceABIAndSDK(s.Device)
+	if err != nil {
+		return err
+	}
+	return installArtifact(ctx, s.log(), s.Device, s.provider, ARTIFACT_MINITOUCH, abi, sdk, dst, 0755)
+}
+
+func (s *STFTouch) runBinary(run *touchRun) (err error) {
+	defer func() {
+		close(run.binDoneC)
+		run.done(err)
+	}()
 	c, err := s.OpenCommand("/data/local/tmp/minitouch")
 	if err != nil {
 		return
 	}
+	s.stateMu.Lock()
+	run.binConn = c
+	s.stateMu.Unlock()
 	defer c.Close()
-	// _, err = io.Copy(ioutil.Discard, c)
-	_, err = io.Copy(os.Stdout, c)
+	w := &debugWriter{l: s.log(), msg: "minitouch output"}
+	defer w.Flush()
+	_, err = io.Copy(w, c)
 	return nil
 }
 
-func (s *STFTouch) drainCmd() {
-	if err := s.dialWithRetry(); err != nil {
-		s.doneError(errors.Wrap(err, "dial minitouch"))
+func (s *STFTouch) drainCmd(ctx context.Context, run *touchRun) {
+	defer close(run.deadC)
+	conn, err := s.dialWithRetry(ctx)
+	if err != nil {
+		if ctx.Err() == nil { // not stopped
+			run.done(errors.Wrap(err, "dial minitouch"))
+			s.killBinary(run)
+		}
 		return
 	}
-	for c := range s.cmdC {
-		c = strings.TrimSpace(c) + "\nc\n" // c: commit
-		_, err := io.WriteString(s.conn, c)
-		if err != nil {
-			s.doneError(errors.Wrap(err, "write command to minitouch tcp"))
-			s.conn.Close()
-			s.conn = nil
-			break
+	defer conn.Close()
+	close(run.readyC)
+	for {
+		select {
+		case req := <-run.cmdC:
+			_, err := io.WriteString(conn, strings.TrimSpace(req.cmds)+"\n")
+			req.errC <- err
+			if err != nil {
+				run.done(errors.Wrap(err, "write command to minitouch tcp"))
+				s.killBinary(run)
+				return
+			}
+		case <-run.binDoneC:
+			return
 		}
 	}
 }
 
+// killBinary make minitouch quit when it can not be used, so runBinary does not leak
+func (s *STFTouch) killBinary(run *touchRun) {
+	s.killProc("minitouch", syscall.SIGKILL)
+	s.closeBinConn(run)
+}
+
+func (s *STFTouch) closeBinConn(run *touchRun) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if run.binConn != nil {
+		run.binConn.Close()
+	}
+}
+
 type lineFormatReader struct {
 	bufrd *bufio.Reader
 	err   error
@@ -218,40 +498,48 @@ func (r *lineFormatReader) Scanf(format string, args ...interface{}) error {
 	return r.err
 }
 
-func (s *STFTouch) dialWithRetry() error {
-	var err error
+func (s *STFTouch) dialWithRetry(ctx context.Context) (conn net.Conn, err error) {
 	for i := 0; i < 10; i++ {
-		err = s.dialTouch()
+		conn, err = s.dialTouch(ctx)
 		if err == nil {
-			return nil
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		s.log().Warn("dial minitouch failed, retrying", "err", err)
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		log.Println("dial minitouch service fail, reconnect, err is", err)
-		time.Sleep(100 * time.Millisecond)
 	}
-	return err
+	return nil, err
 }
 
-func (s *STFTouch) dialTouch() error {
+func (s *STFTouch) dialTouch(ctx context.Context) (net.Conn, error) {
 	port, err := s.ForwardToFreePort(adb.ForwardSpec{adb.FProtocolAbstract, "minitouch"})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	s.conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	lineRd := lineFormatReader{bufrd: bufio.NewReader(s.conn)}
+	lineRd := lineFormatReader{bufrd: bufio.NewReader(conn)}
 	var flag string
-	var ver int
-	var maxContacts, maxPressure int
-	var pid int
-	lineRd.Scanf("%s %d", &flag, &ver)
-	lineRd.Scanf("%s %d %d %d %d", &flag, &maxContacts, &s.maxX, &s.maxY, &maxPressure)
-	if err := lineRd.Scanf("%s %d", &flag, &pid); err != nil {
-		s.conn.Close()
-		return err
+	var info TouchInfo
+	lineRd.Scanf("%s %d", &flag, &info.Version)
+	lineRd.Scanf("%s %d %d %d %d", &flag, &info.MaxContacts, &info.MaxX, &info.MaxY, &info.MaxPressure)
+	if err := lineRd.Scanf("%s %d", &flag, &info.Pid); err != nil {
+		conn.Close()
+		return nil, err
 	}
-	return nil
+	s.stateMu.Lock()
+	s.info = info
+	s.stateMu.Unlock()
+	return conn, nil
 }
 
 // FIXME(ssx): maybe need to put into go-adb
@@ -7,13 +7,55 @@ import (
 )
 
 func TestTouch(t *testing.T) {
-	touch := NewSTFTouch(dev)
+	touch := NewSTFTouch(dev, nil)
 	err := touch.Start()
 	assert.NoError(t, err)
-	touch.Down(0, 100, 330)
-	touch.Up(0)
+	assert.NoError(t, touch.Down(0, 0.5, 0.5))
+	assert.NoError(t, touch.Up(0))
 	err = touch.Stop()
 	assert.NoError(t, err)
 	err = touch.Wait()
 	assert.NoError(t, err)
 }
+
+func TestTouchCommand(t *testing.T) {
+	touch := &STFTouch{info: TouchInfo{MaxContacts: 2, MaxX: 1000, MaxY: 2000, MaxPressure: 100}}
+	cmd, err := touch.command(TouchEvent{Action: TOUCH_DOWN, Index: 1, X: 0.25, Y: 0.25})
+	assert.NoError(t, err)
+	assert.Equal(t, "d 1 250 500 50", cmd)
+	cmd, _ = touch.command(TouchEvent{Action: TOUCH_DOWN, Index: 0, X: 0.25, Y: 0.25, Pressure: 0.2})
+	assert.Equal(t, "d 0 250 500 20", cmd)
+	cmd, _ = touch.command(TouchEvent{Action: TOUCH_UP, Index: 1})
+	assert.Equal(t, "u 1", cmd)
+	_, err = touch.command(TouchEvent{Action: TOUCH_UP, Index: 2})
+	assert.Error(t, err)
+	_, err = touch.command(TouchEvent{Action: TOUCH_MOVE, Index: 0, Pressure: 1.5})
+	assert.Error(t, err)
+
+	touch.SetRotation(90)
+	w, h := touch.Size()
+	assert.Equal(t, 2000, w)
+	assert.Equal(t, 1000, h)
+	cmd, _ = touch.command(TouchEvent{Action: TOUCH_MOVE, Index: 0, X: 0.25, Y: 0.25})
+	assert.Equal(t, "m 0 750 500 50", cmd)
+
+	xP, yP, err := touch.percent(500, 500)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.25, xP)
+	assert.Equal(t, 0.5, yP)
+	_, _, err = (&STFTouch{}).percent(1, 1)
+	assert.Equal(t, ErrTouchNotReady, err)
+}
+
+func TestTouchCapabilities(t *testing.T) {
+	touch := &STFTouch{}
+	assert.Equal(t, TouchCapabilities{}, touch.Capabilities())
+	w, h := touch.Size()
+	assert.Equal(t, 0, w)
+	assert.Equal(t, 0, h)
+
+	touch.info = TouchInfo{MaxContacts: 1, MaxX: 1000, MaxY: 2000}
+	assert.Equal(t, TouchCapabilities{MaxContacts: 1, Move: true}, touch.Capabilities())
+	touch.info = TouchInfo{MaxContacts: 10, MaxX: 1000, MaxY: 2000, MaxPressure: 255}
+	assert.Equal(t, TouchCapabilities{MultiTouch: true, MaxContacts: 10, Pressure: true, Move: true}, touch.Capabilities())
+}
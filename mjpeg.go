@@ -0,0 +1,124 @@
+package stf
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const mjpegBoundary = "gostfmjpegboundary"
+
+var _ FrameSource = (*STFCapturer)(nil)
+
+// FrameSource provide jpeg frames, STFCapturer is a FrameSource
+type FrameSource interface {
+	SubscribeFrames(policy BackpressurePolicy, size int) *Subscription
+	UnsubscribeFrames(sub *Subscription)
+	LastFrame() (*Frame, error)
+}
+
+// MJPEGHandler serve multipart/x-mixed-replace stream which can be shown in <img> directly.
+// Every viewer have its own subscription, slow viewers always get the latest frame.
+type MJPEGHandler struct {
+	src FrameSource
+	// MaxFPS limit frames sent to each viewer, 0 means no limit.
+	// Viewer can ask for a lower rate with query ?fps=N
+	MaxFPS int
+}
+
+func NewMJPEGHandler(src FrameSource) *MJPEGHandler {
+	return &MJPEGHandler{src: src}
+}
+
+func (h *MJPEGHandler) fps(r *http.Request) int {
+	fps := h.MaxFPS
+	if v, err := strconv.Atoi(r.URL.Query().Get("fps")); err == nil && v > 0 {
+		if fps == 0 || v < fps {
+			fps = v
+		}
+	}
+	return fps
+}
+
+func (h *MJPEGHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var interval time.Duration
+	if fps := h.fps(r); fps > 0 {
+		interval = time.Second / time.Duration(fps)
+	}
+	sub := h.src.SubscribeFrames(LatestOnly, 1)
+	defer h.src.UnsubscribeFrames(sub)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	ctx := r.Context()
+	for {
+		var f *Frame
+		var ok bool
+		select {
+		case f, ok = <-sub.C:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		if f.Event != FRAME_IMAGE {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n",
+			mjpegBoundary, len(f.Data))
+		if err == nil {
+			_, err = w.Write(f.Data)
+		}
+		if err == nil {
+			_, err = w.Write([]byte("\r\n"))
+		}
+		if err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		// frames arrived during sleep are folded into the latest one
+		if interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// ScreenshotHandler return the latest cached frame as image/jpeg
+func ScreenshotHandler(src FrameSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := src.LastFrame()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(len(f.Data)))
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Write(f.Data)
+	})
+}
+
+// NewScreenServeMux register /stream.mjpeg and /screenshot.jpg
+func NewScreenServeMux(src FrameSource, maxFPS int) *http.ServeMux {
+	mjpeg := NewMJPEGHandler(src)
+	mjpeg.MaxFPS = maxFPS
+	mux := http.NewServeMux()
+	mux.Handle("/stream.mjpeg", mjpeg)
+	mux.Handle("/screenshot.jpg", ScreenshotHandler(src))
+	return mux
+}
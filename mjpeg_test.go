@@ -0,0 +1,97 @@
+package stf
+
+import (
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeFrameSource struct {
+	*Broadcaster
+	mu   sync.Mutex
+	last *Frame
+}
+
+func (s *fakeFrameSource) SubscribeFrames(policy BackpressurePolicy, size int) *Subscription {
+	return s.Subscribe(policy, size)
+}
+
+func (s *fakeFrameSource) UnsubscribeFrames(sub *Subscription) {
+	s.Unsubscribe(sub)
+}
+
+func (s *fakeFrameSource) LastFrame() (*Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		return nil, errors.New("no image captured yet")
+	}
+	return s.last, nil
+}
+
+func (s *fakeFrameSource) pub(f *Frame) {
+	s.mu.Lock()
+	s.last = f
+	s.mu.Unlock()
+	s.Publish(f)
+}
+
+func TestMJPEGHandler(t *testing.T) {
+	src := &fakeFrameSource{Broadcaster: NewBroadcaster()}
+	ts := httptest.NewServer(NewScreenServeMux(src, 0))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/screenshot.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + "/stream.mjpeg?fps=100")
+	assert.NoError(t, err)
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+
+	stopC := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-time.After(10 * time.Millisecond):
+				src.pub(&Frame{Data: []byte("\xff\xd8jpeg")})
+			}
+		}
+	}()
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for i := 0; i < 3; i++ {
+		part, err := mr.NextPart()
+		assert.NoError(t, err)
+		assert.Equal(t, "image/jpeg", part.Header.Get("Content-Type"))
+		data, _ := ioutil.ReadAll(part)
+		assert.Equal(t, "\xff\xd8jpeg", string(data))
+	}
+	resp.Body.Close()
+	close(stopC)
+
+	// viewer subscription should be released after client disconnect
+	for i := 0; i < 100 && src.Len() > 0; i++ {
+		src.pub(&Frame{Data: []byte("\xff\xd8jpeg")})
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, src.Len())
+
+	resp, err = http.Get(ts.URL + "/screenshot.jpg")
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "\xff\xd8jpeg", string(data))
+}
@@ -0,0 +1,230 @@
+package stf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// artifact names used by BinaryProvider
+const (
+	ARTIFACT_MINICAP          = "minicap"
+	ARTIFACT_MINICAP_SO       = "minicap.so"
+	ARTIFACT_SLOW_MINICAP     = "slow-minicap"
+	ARTIFACT_MINITOUCH        = "minitouch"
+	ARTIFACT_ROTATION_WATCHER = "RotationWatcher.apk"
+)
+
+// BinaryProvider resolve vendor binaries which need to be pushed to device
+type BinaryProvider interface {
+	Open(name, abi, sdk string) (io.ReadCloser, error)
+}
+
+// ArtifactPath return relative path of an artifact, shared by all providers
+//
+//	minicap/bin/<abi>/minicap
+//	minicap/shared/android-<sdk>/<abi>/minicap.so
+//	slow-minicap/<abi>/slow-minicap
+//	minitouch/<abi>/minitouch
+//	RotationWatcher.apk
+func ArtifactPath(name, abi, sdk string) (string, error) {
+	switch name {
+	case ARTIFACT_MINICAP:
+		return "minicap/bin/" + abi + "/minicap", nil
+	case ARTIFACT_MINICAP_SO:
+		return "minicap/shared/android-" + sdk + "/" + abi + "/minicap.so", nil
+	case ARTIFACT_SLOW_MINICAP:
+		return "slow-minicap/" + abi + "/slow-minicap", nil
+	case ARTIFACT_MINITOUCH:
+		return "minitouch/" + abi + "/minitouch", nil
+	case ARTIFACT_ROTATION_WATCHER:
+		return "RotationWatcher.apk", nil
+	default:
+		return "", errors.New("unknown artifact " + name)
+	}
+}
+
+// ContextBinaryProvider is optionally implemented by BinaryProvider which can be cancelled
+type ContextBinaryProvider interface {
+	OpenContext(ctx context.Context, name, abi, sdk string) (io.ReadCloser, error)
+}
+
+// openArtifact use OpenContext if possible, reading is stopped once ctx is done
+func openArtifact(ctx context.Context, p BinaryProvider, name, abi, sdk string) (io.ReadCloser, error) {
+	if cp, ok := p.(ContextBinaryProvider); ok {
+		return cp.OpenContext(ctx, name, abi, sdk)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rc, err := p.Open(name, abi, sdk)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{ctxReader{ctx, rc}, rc}, nil
+}
+
+// HTTPBinaryProvider download artifacts through http
+type HTTPBinaryProvider struct {
+	URL    func(name, abi, sdk string) (string, error)
+	Client *http.Client
+}
+
+// NewHTTPBinaryProvider download artifacts from baseURL with the layout of ArtifactPath
+func NewHTTPBinaryProvider(baseURL string) *HTTPBinaryProvider {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return &HTTPBinaryProvider{
+		URL: func(name, abi, sdk string) (string, error) {
+			p, err := ArtifactPath(name, abi, sdk)
+			if err != nil {
+				return "", err
+			}
+			return baseURL + "/" + p, nil
+		},
+	}
+}
+
+func (p *HTTPBinaryProvider) Open(name, abi, sdk string) (io.ReadCloser, error) {
+	return p.OpenContext(context.Background(), name, abi, sdk)
+}
+
+// OpenContext abort downloading when ctx is done
+func (p *HTTPBinaryProvider) OpenContext(ctx context.Context, name, abi, sdk string) (io.ReadCloser, error) {
+	urlStr, err := p.URL(name, abi, sdk)
+	if err != nil {
+		return nil, err
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http download <%s> status %v", urlStr, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// headTimeout limit the HEAD request of Checksum, artifact is downloaded when it fails
+const headTimeout = 10 * time.Second
+
+// Checksum return only the size from Content-Length of a HEAD request,
+// so that artifacts are not downloaded when device files have the same size
+func (p *HTTPBinaryProvider) Checksum(name, abi, sdk string) (Checksum, bool) {
+	return p.ChecksumContext(context.Background(), name, abi, sdk)
+}
+
+func (p *HTTPBinaryProvider) ChecksumContext(ctx context.Context, name, abi, sdk string) (Checksum, bool) {
+	urlStr, err := p.URL(name, abi, sdk)
+	if err != nil {
+		return Checksum{}, false
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(ctx, headTimeout)
+	defer cancel()
+	req, err := http.NewRequest("HEAD", urlStr, nil)
+	if err != nil {
+		return Checksum{}, false
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept-Encoding", "identity") // Content-Length of compressed body is useless
+	resp, err := client.Do(req)
+	if err != nil {
+		return Checksum{}, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 {
+		return Checksum{}, false
+	}
+	return Checksum{Size: resp.ContentLength}, true
+}
+
+// FSBinaryProvider read artifacts from a filesystem, eg: embed.FS
+type FSBinaryProvider struct {
+	fsys fs.FS
+}
+
+// NewFSBinaryProvider work well with go:embed
+//
+//	//go:embed vendor
+//	var vendorFS embed.FS
+//
+//	sub, _ := fs.Sub(vendorFS, "vendor")
+//	provider := stf.NewFSBinaryProvider(sub)
+func NewFSBinaryProvider(fsys fs.FS) *FSBinaryProvider {
+	return &FSBinaryProvider{fsys: fsys}
+}
+
+// NewDirBinaryProvider read artifacts from local directory tree
+func NewDirBinaryProvider(dir string) *FSBinaryProvider {
+	return NewFSBinaryProvider(os.DirFS(dir))
+}
+
+func (p *FSBinaryProvider) Open(name, abi, sdk string) (io.ReadCloser, error) {
+	relPath, err := ArtifactPath(name, abi, sdk)
+	if err != nil {
+		return nil, err
+	}
+	return p.fsys.Open(path.Clean(relPath))
+}
+
+// Checksum hash the local file, it is cheap compared with pushing to device
+func (p *FSBinaryProvider) Checksum(name, abi, sdk string) (Checksum, bool) {
+	relPath, err := ArtifactPath(name, abi, sdk)
+	if err != nil {
+		return Checksum{}, false
+	}
+	data, err := fs.ReadFile(p.fsys, path.Clean(relPath))
+	if err != nil {
+		return Checksum{}, false
+	}
+	return checksumOf(data), true
+}
+
+// DefaultBinaryProvider download artifacts from public mirrors, used when provider is nil
+var DefaultBinaryProvider BinaryProvider = &HTTPBinaryProvider{URL: defaultArtifactURL}
+
+func defaultArtifactURL(name, abi, sdk string) (string, error) {
+	switch name {
+	case ARTIFACT_SLOW_MINICAP:
+		return "https://gohttp.nie.netease.com/yosemite/slow-minicap/" + abi + "/slow-minicap", nil
+	case ARTIFACT_MINITOUCH:
+		return "https://github.com/openstf/stf/raw/master/vendor/minitouch/" + abi + "/minitouch", nil
+	case ARTIFACT_ROTATION_WATCHER:
+		return "https://github.com/openatx/RotationWatcher.apk/releases/download/1.0/RotationWatcher.apk", nil
+	}
+	p, err := ArtifactPath(name, abi, sdk)
+	if err != nil {
+		return "", err
+	}
+	return "https://gohttp.nie.netease.com/openstf/vendor/" + p, nil
+}
+
+func providerOrDefault(p BinaryProvider) BinaryProvider {
+	if p == nil {
+		return DefaultBinaryProvider
+	}
+	return p
+}
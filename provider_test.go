@@ -0,0 +1,90 @@
+package stf
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readArtifact(t *testing.T, p BinaryProvider, name string) string {
+	rc, err := p.Open(name, "arm64-v8a", "23")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, _ := ioutil.ReadAll(rc)
+	return string(data)
+}
+
+func TestArtifactPath(t *testing.T) {
+	p, err := ArtifactPath(ARTIFACT_MINICAP_SO, "x86", "25")
+	assert.NoError(t, err)
+	assert.Equal(t, "minicap/shared/android-25/x86/minicap.so", p)
+	_, err = ArtifactPath("unknown", "x86", "25")
+	assert.Error(t, err)
+}
+
+func TestFSBinaryProvider(t *testing.T) {
+	fsys := fstest.MapFS{
+		"minitouch/arm64-v8a/minitouch": &fstest.MapFile{Data: []byte("minitouch")},
+	}
+	p := NewFSBinaryProvider(fsys)
+	assert.Equal(t, "minitouch", readArtifact(t, p, ARTIFACT_MINITOUCH))
+	_, err := p.Open(ARTIFACT_MINICAP, "arm64-v8a", "23")
+	assert.Error(t, err)
+}
+
+func TestDirBinaryProvider(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "RotationWatcher.apk"), []byte("apk"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "minicap/bin/arm64-v8a"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "minicap/bin/arm64-v8a/minicap"), []byte("bin"), 0755))
+
+	p := NewDirBinaryProvider(dir)
+	assert.Equal(t, "apk", readArtifact(t, p, ARTIFACT_ROTATION_WATCHER))
+	assert.Equal(t, "bin", readArtifact(t, p, ARTIFACT_MINICAP))
+}
+
+func TestHTTPBinaryProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vendor/slow-minicap/arm64-v8a/slow-minicap" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer ts.Close()
+
+	p := NewHTTPBinaryProvider(ts.URL + "/vendor/")
+	assert.Equal(t, "slow", readArtifact(t, p, ARTIFACT_SLOW_MINICAP))
+	_, err := p.Open(ARTIFACT_MINICAP, "arm64-v8a", "23")
+	assert.Error(t, err)
+}
+
+func TestOpenArtifactContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := openArtifact(ctx, NewHTTPBinaryProvider(ts.URL), ARTIFACT_MINITOUCH, "x86", "")
+	assert.Error(t, err)
+
+	fsys := fstest.MapFS{"minitouch/x86/minitouch": &fstest.MapFile{Data: []byte("binary")}}
+	rc, err := openArtifact(ctx, NewFSBinaryProvider(fsys), ARTIFACT_MINITOUCH, "x86", "")
+	assert.Error(t, err, "ctx is already done")
+	rc, err = openArtifact(context.Background(), NewFSBinaryProvider(fsys), ARTIFACT_MINITOUCH, "x86", "")
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadAll(rc)
+	assert.Equal(t, "binary", string(data))
+	assert.NoError(t, rc.Close())
+}
@@ -0,0 +1,227 @@
+package stf
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const touchRecordingVersion = 1
+
+// RecordedTouch is one touch event in TouchRecording.
+// Events with the same Time are committed together when replay.
+type RecordedTouch struct {
+	Time     int64   `json:"time"`   // milliseconds since the first event
+	Action   string  `json:"action"` // d: down, m: move, u: up
+	Index    int     `json:"index"`  // contact id
+	X        float64 `json:"x,omitempty"`
+	Y        float64 `json:"y,omitempty"`
+	Pressure float64 `json:"pressure,omitempty"`
+}
+
+// TouchRecording is saved as json, eg:
+//
+//	{
+//	  "version": 1,
+//	  "width": 1080,
+//	  "height": 1920,
+//	  "rotation": 0,
+//	  "events": [
+//	    {"time": 0, "action": "d", "index": 0, "x": 0.5, "y": 0.8},
+//	    {"time": 16, "action": "m", "index": 0, "x": 0.5, "y": 0.6},
+//	    {"time": 32, "action": "u", "index": 0}
+//	  ]
+//	}
+//
+// x and y are percentage of screen size in the rotation of recording,
+// width and height are screen size in pixels when recording started.
+type TouchRecording struct {
+	Version  int             `json:"version"`
+	Width    int             `json:"width"`
+	Height   int             `json:"height"`
+	Rotation int             `json:"rotation"`
+	Events   []RecordedTouch `json:"events"`
+}
+
+func LoadTouchRecording(rd io.Reader) (*TouchRecording, error) {
+	rec := &TouchRecording{}
+	if err := json.NewDecoder(rd).Decode(rec); err != nil {
+		return nil, err
+	}
+	if rec.Version != touchRecordingVersion {
+		return nil, errors.Errorf("unsupported touch recording version %d", rec.Version)
+	}
+	return rec, nil
+}
+
+func (rec *TouchRecording) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rec)
+}
+
+// TouchRecorder wrap a Toucher and record every successful touch event
+type TouchRecorder struct {
+	Toucher
+	mu    sync.Mutex
+	start time.Time
+	rec   TouchRecording
+	now   func() time.Time
+}
+
+func NewTouchRecorder(t Toucher) *TouchRecorder {
+	r := &TouchRecorder{Toucher: t, now: time.Now}
+	r.Reset()
+	return r
+}
+
+// Reset drop all recorded events
+func (r *TouchRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start = time.Time{}
+	r.rec = TouchRecording{Version: touchRecordingVersion, Events: []RecordedTouch{}}
+}
+
+// Recording return a copy of recorded events
+func (r *TouchRecorder) Recording() *TouchRecording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.rec
+	rec.Events = append([]RecordedTouch(nil), r.rec.Events...)
+	return &rec
+}
+
+func (r *TouchRecorder) record(events []TouchEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if r.start.IsZero() {
+		r.start = now
+		r.rec.Width, r.rec.Height = r.Toucher.Size()
+		if rt, ok := r.Toucher.(interface{ Rotation() int }); ok {
+			r.rec.Rotation = rt.Rotation()
+		}
+	}
+	ms := int64(now.Sub(r.start) / time.Millisecond)
+	for _, e := range events {
+		rt := RecordedTouch{Time: ms, Action: e.Action.String(), Index: e.Index}
+		if e.Action != TOUCH_UP {
+			rt.X, rt.Y, rt.Pressure = e.X, e.Y, e.Pressure
+		}
+		r.rec.Events = append(r.rec.Events, rt)
+	}
+}
+
+func (r *TouchRecorder) Do(events ...TouchEvent) error {
+	if err := r.Toucher.Do(events...); err != nil {
+		return err
+	}
+	r.record(events)
+	return nil
+}
+
+func (r *TouchRecorder) Down(index int, xP, yP float64) error {
+	return r.Do(TouchEvent{Action: TOUCH_DOWN, Index: index, X: xP, Y: yP})
+}
+
+func (r *TouchRecorder) Move(index int, xP, yP float64) error {
+	return r.Do(TouchEvent{Action: TOUCH_MOVE, Index: index, X: xP, Y: yP})
+}
+
+func (r *TouchRecorder) Up(index int) error {
+	return r.Do(TouchEvent{Action: TOUCH_UP, Index: index})
+}
+
+func (r *TouchRecorder) percent(x, y int) (float64, float64, error) {
+	width, height := r.Toucher.Size()
+	if width == 0 || height == 0 {
+		return 0, 0, ErrTouchNotReady
+	}
+	return float64(x) / float64(width), float64(y) / float64(height), nil
+}
+
+func (r *TouchRecorder) DownPx(index int, x, y int) error {
+	xP, yP, err := r.percent(x, y)
+	if err != nil {
+		return err
+	}
+	return r.Down(index, xP, yP)
+}
+
+func (r *TouchRecorder) MovePx(index int, x, y int) error {
+	xP, yP, err := r.percent(x, y)
+	if err != nil {
+		return err
+	}
+	return r.Move(index, xP, yP)
+}
+
+// ReplayOptions control how TouchRecording is played
+type ReplayOptions struct {
+	Speed float64 // 2 means twice as fast, 0 means 1
+	// Rotate recorded coordinates clockwise, one of 0, 90, 180, 270
+	Rotate int
+}
+
+func rotatePoint(x, y float64, rotate int) (float64, float64) {
+	switch rotate {
+	case 90:
+		return 1 - y, x
+	case 180:
+		return 1 - x, 1 - y
+	case 270:
+		return y, 1 - x
+	}
+	return x, y
+}
+
+// Script convert recording to TouchScript
+func (rec *TouchRecording) Script(opts ReplayOptions) (*TouchScript, error) {
+	speed := opts.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	if speed < 0 {
+		return nil, errors.New("replay speed must be positive")
+	}
+	switch opts.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return nil, errors.Errorf("invalid rotate %d", opts.Rotate)
+	}
+	script := NewTouchScript()
+	var last int64
+	for i, rt := range rec.Events {
+		if i > 0 && rt.Time != last {
+			script.Commit().Wait(time.Duration(float64(rt.Time-last)/speed) * time.Millisecond)
+		}
+		last = rt.Time
+		e := TouchEvent{Index: rt.Index, Pressure: rt.Pressure}
+		switch rt.Action {
+		case "d":
+			e.Action = TOUCH_DOWN
+		case "m":
+			e.Action = TOUCH_MOVE
+		case "u":
+			e.Action = TOUCH_UP
+		default:
+			return nil, errors.Errorf("unknown action %q at event %d", rt.Action, i)
+		}
+		e.X, e.Y = rotatePoint(rt.X, rt.Y, opts.Rotate)
+		script.Event(e)
+	}
+	return script.Commit(), nil
+}
+
+// ReplayTouch play recording with toucher, device side timing is used if possible
+func ReplayTouch(t Toucher, rec *TouchRecording, opts ReplayOptions) error {
+	script, err := rec.Script(opts)
+	if err != nil {
+		return err
+	}
+	return NewGestures(t).Run(script)
+}
@@ -0,0 +1,65 @@
+package stf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTouchRecorder(t *testing.T) {
+	fake := &fakeToucher{}
+	r := NewTouchRecorder(fake)
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+
+	assert.NoError(t, r.Down(0, 0.25, 0.5))
+	now = now.Add(20 * time.Millisecond)
+	assert.NoError(t, r.Do(
+		TouchEvent{Action: TOUCH_MOVE, Index: 0, X: 0.25, Y: 0.25},
+		TouchEvent{Action: TOUCH_DOWN, Index: 1, X: 0.75, Y: 0.75}))
+	now = now.Add(30 * time.Millisecond)
+	assert.NoError(t, r.DownPx(2, 500, 1000))
+	assert.NoError(t, r.Up(0))
+
+	rec := r.Recording()
+	assert.Equal(t, 1000, rec.Width)
+	assert.Len(t, rec.Events, 5)
+	assert.Equal(t, RecordedTouch{Time: 20, Action: "d", Index: 1, X: 0.75, Y: 0.75}, rec.Events[2])
+	assert.Equal(t, RecordedTouch{Time: 50, Action: "d", Index: 2, X: 0.5, Y: 0.5}, rec.Events[3])
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, rec.Save(buf))
+	loaded, err := LoadTouchRecording(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, rec, loaded)
+
+	_, err = LoadTouchRecording(strings.NewReader(`{"version": 2}`))
+	assert.Error(t, err)
+}
+
+func TestReplayTouch(t *testing.T) {
+	rec := &TouchRecording{Version: 1, Events: []RecordedTouch{
+		{Time: 0, Action: "d", Index: 0, X: 0.25, Y: 0.5},
+		{Time: 100, Action: "m", Index: 0, X: 0.5, Y: 0.5},
+		{Time: 100, Action: "d", Index: 1, X: 0.1, Y: 0.2},
+		{Time: 300, Action: "u", Index: 0},
+	}}
+	script, err := rec.Script(ReplayOptions{Speed: 2, Rotate: 90})
+	assert.NoError(t, err)
+	assert.Equal(t, 150*time.Millisecond, script.Duration())
+
+	touch := &STFTouch{info: TouchInfo{MaxX: 100, MaxY: 100, MaxPressure: 100}}
+	cmds, err := touch.scriptCommands(script)
+	assert.NoError(t, err)
+	assert.Equal(t, "d 0 50 25 50\nc\nw 50\nm 0 50 50 50\nd 1 80 10 50\nc\nw 100\nu 0\nc", cmds)
+
+	_, err = rec.Script(ReplayOptions{Rotate: 45})
+	assert.Error(t, err)
+
+	fake := &fakeToucher{}
+	assert.NoError(t, ReplayTouch(fake, rec, ReplayOptions{Speed: 100}))
+	assert.Len(t, fake.commits, 3)
+}
@@ -3,146 +3,188 @@ package stf
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	adb "github.com/openatx/go-adb"
-	"github.com/openatx/go-adb/wire"
 	"github.com/pkg/errors"
 )
 
 const (
-	defaultRotationPkgName  = "jp.co.cyberagent.stf.rotationwatcher"
-	defaultRotationMaxRetry = 3
+	defaultRotationPkgName      = "jp.co.cyberagent.stf.rotationwatcher"
+	defaultRotationMaxRetry     = 3
+	defaultRotationRetryBackoff = time.Second
 )
 
 type STFRotation struct {
-	d           *adb.Device
-	mu          sync.Mutex
-	lastValue   int
-	subscribers map[chan int]bool
-	cmdConn     *wire.Conn
-	wg          sync.WaitGroup
-	stopped     bool
-	leftRetry   int
+	// MaxRetry is how many times watcher can fail continuously before give up
+	MaxRetry int
+	// RetryBackoff is the wait before first retry, doubled for each following retry
+	RetryBackoff time.Duration
+
+	d        *adb.Device
+	stateMu  sync.Mutex
+	cmdConn  io.Closer
+	quitC    chan bool
+	provider BinaryProvider
+	prepare  func(ctx context.Context) (string, error)
+	open     func(pmPath string) (io.ReadCloser, error)
+
+	*rotationHub
+	errorMixin
+	safeMixin
+	logMixin
 }
 
-func NewSTFRotation(d *adb.Device) *STFRotation {
-	return &STFRotation{
-		d:           d,
-		subscribers: make(map[chan int]bool),
-		leftRetry:   defaultRotationMaxRetry,
-		lastValue:   -1,
-	}
+// NewSTFRotation create rotation watcher, provider can be nil to use DefaultBinaryProvider
+func NewSTFRotation(d *adb.Device, provider BinaryProvider) *STFRotation {
+	s := &STFRotation{
+		MaxRetry:     defaultRotationMaxRetry,
+		RetryBackoff: defaultRotationRetryBackoff,
+		d:            d,
+		provider:     provider,
+		rotationHub:  newRotationHub(),
+	}
+	s.initLogger(d, "rotation")
+	s.prepare = s.preparePackage
+	s.open = func(pmPath string) (io.ReadCloser, error) {
+		return s.d.OpenCommand("CLASSPATH="+pmPath, "exec", "app_process", "/system/bin", defaultRotationPkgName+".RotationWatcher")
+	}
+	return s
 }
 
-// 0, 90, 180, 270
-func (s *STFRotation) Rotation() (int, error) {
-	if s.lastValue == -1 || s.stopped {
-		return 0, errors.New("Rotation not ready")
-	}
-	return s.lastValue, nil
-}
+var _ ContextServicer = (*STFRotation)(nil)
 
 func (s *STFRotation) Start() error {
-	pmPath, err := s.preparePackage()
-	if err != nil {
-		return err
-	}
+	return s.StartContext(context.Background())
+}
 
-	go func() {
-		var ok = true
-		for ok {
-			s.wg.Add(1)
-			err := s.consoleStartProcess(pmPath)
-			if err == nil {
-				s.leftRetry = defaultRotationMaxRetry
-			} else {
-				log.Printf("rotation run failed: %v, left retry %d", err, s.leftRetry)
-			}
-
-			s.mu.Lock()
-			s.leftRetry -= 1
-			if s.stopped || s.leftRetry <= 0 {
-				for subC := range s.subscribers {
-					s.Unsubscribe(subC)
-				}
-				ok = false
-			}
-			s.wg.Done()
-			s.mu.Unlock()
+// StartContext abort installing RotationWatcher.apk when ctx is done
+func (s *STFRotation) StartContext(ctx context.Context) error {
+	return s.safeDo(_ACTION_START, func() error {
+		run := s.resetError()
+		s.watchState(s.Wait)
+		pmPath, err := s.prepare(ctx)
+		if err != nil {
+			run.done(err)
+			return err
 		}
-	}()
-	return nil
+		quitC := make(chan bool)
+		s.stateMu.Lock()
+		s.quitC = quitC
+		s.stateMu.Unlock()
+		go s.keepRunning(pmPath, quitC, run)
+		return nil
+	})
 }
 
 func (s *STFRotation) Stop() error {
-	// cancel retry and wait until stop
-	s.mu.Lock()
-	s.stopped = true
-	s.mu.Unlock()
-	if s.cmdConn != nil {
-		s.cmdConn.Close()
-		s.cmdConn = nil
-	}
-	s.wg.Wait()
-	return nil
+	return stopWithTimeout(s.StopContext)
 }
 
-func (s *STFRotation) Subscribe() chan int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	C := make(chan int, 1)
-	s.subscribers[C] = true
-	return C
-}
-
-// unsubscribe will also close channel
-func (s *STFRotation) Unsubscribe(C chan int) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.subscribers, C)
-	close(C)
+// StopContext kill watcher process and wait until it quit, or ctx is done
+func (s *STFRotation) StopContext(ctx context.Context) error {
+	return s.safeDo(_ACTION_STOP, func() error {
+		s.stateMu.Lock()
+		close(s.quitC)
+		if s.cmdConn != nil {
+			s.cmdConn.Close()
+		}
+		s.stateMu.Unlock()
+		return waitContext(ctx, s.Wait, func(err error) {
+			s.currentRun().doneWith(errors.Wrap(err, "force stop rotation"), s.close)
+		})
+	})
 }
 
-func (s *STFRotation) pub(v int) {
-	s.lastValue = v
-	for subC := range s.subscribers {
+// keepRunning restart watcher until stopped or retries are exhausted,
+// subscribers are closed when it returns, unless the run is already force stopped
+func (s *STFRotation) keepRunning(pmPath string, quitC chan bool, run *errorRun) {
+	var err error
+	defer func() {
+		run.doneWith(err, s.close)
+	}()
+	leftRetry, backoff := s.MaxRetry, s.RetryBackoff
+	for {
+		err = s.consoleStartProcess(pmPath, quitC)
+		if isClosed(quitC) {
+			err = nil
+			return
+		}
+		if err == nil { // worked for a while, count again
+			leftRetry, backoff = s.MaxRetry, s.RetryBackoff
+			err = errors.New("rotation watcher quit")
+		}
+		leftRetry -= 1
+		if leftRetry <= 0 {
+			err = errors.Wrap(err, "rotation")
+			return
+		}
+		s.log().Warn("rotation watcher failed, retrying",
+			"err", err, "leftRetry", leftRetry)
+		s.restarting(err)
 		select {
-		case subC <- v:
-		case <-time.After(1 * time.Second):
-			s.Unsubscribe(subC)
+		case <-time.After(backoff):
+		case <-quitC:
+			err = nil
+			return
 		}
+		backoff *= 2
 	}
 }
 
-func (s *STFRotation) preparePackage() (pmPath string, err error) {
-	if err := s.pushApk(); err != nil {
+func isClosed(C chan bool) bool {
+	select {
+	case <-C:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *STFRotation) preparePackage(ctx context.Context) (pmPath string, err error) {
+	if err := s.pushApk(ctx); err != nil {
 		return "", err
 	}
 	return s.getPackagePath(defaultRotationPkgName)
 }
 
-func (s *STFRotation) consoleStartProcess(pmPath string) error {
-	fio, err := s.d.OpenCommand("CLASSPATH="+pmPath, "exec", "app_process", "/system/bin", defaultRotationPkgName+".RotationWatcher")
+func (s *STFRotation) consoleStartProcess(pmPath string, quitC chan bool) error {
+	fio, err := s.open(pmPath)
 	if err != nil {
 		return errors.Wrap(err, "start rotation.apk")
 	}
-	s.cmdConn = fio
 	defer fio.Close()
+	s.stateMu.Lock()
+	if isClosed(quitC) {
+		s.stateMu.Unlock()
+		return nil
+	}
+	s.cmdConn = fio
+	s.stateMu.Unlock()
+	defer func() {
+		s.stateMu.Lock()
+		if s.cmdConn == fio {
+			s.cmdConn = nil
+		}
+		s.stateMu.Unlock()
+	}()
+
 	readCount := 0
 	scanner := bufio.NewScanner(fio)
 	for scanner.Scan() {
-		val, err := strconv.Atoi(scanner.Text())
+		val, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
 		if err != nil {
 			return err
 		}
+		if readCount == 0 {
+			s.recovered()
+		}
 		readCount += 1
 		s.pub(val)
 	}
@@ -152,30 +194,17 @@ func (s *STFRotation) consoleStartProcess(pmPath string) error {
 	return errors.New("Rotation got nothing")
 }
 
-func (s *STFRotation) pushApk() error {
+func (s *STFRotation) pushApk(ctx context.Context) error {
 	_, err := s.getPackagePath(defaultRotationPkgName) // If already installed, then skip
 	if err == nil {
 		return nil
 	}
 	phoneApkPath := "/data/local/tmp/RotationWatcher.apk"
-	wc, err := s.d.OpenWrite(phoneApkPath, 0644, time.Now())
-	if err != nil {
-		return err
-	}
-	resp, err := http.Get("https://github.com/openatx/RotationWatcher.apk/releases/download/1.0/RotationWatcher.apk")
+	err = installArtifact(ctx, s.log(), s.d, s.provider, ARTIFACT_ROTATION_WATCHER, "", "", phoneApkPath, 0644)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New("http download rotation watcher status " + resp.Status)
-	}
-	defer resp.Body.Close()
-	log.Println("downloading RotationWatcher.apk ...")
-	if _, err = io.Copy(wc, resp.Body); err != nil {
-		return err
-	}
-	log.Println("Done")
-	if err := wc.Close(); err != nil {
+	if err = ctx.Err(); err != nil {
 		return err
 	}
 	_, err = s.checkCmdOutput("pm", "install", "-rt", phoneApkPath)
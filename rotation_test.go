@@ -1,16 +1,20 @@
 package stf
 
 import (
+	"context"
+	"io"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestRotation(t *testing.T) {
 	assert := assert.New(t)
 
-	r := NewSTFRotation(dev)
+	r := NewSTFRotation(dev, nil)
 	subC := r.Subscribe()
 
 	start := time.Now()
@@ -39,3 +43,122 @@ func TestRotation(t *testing.T) {
 // 	t.Log(fio.Close())
 // 	time.Sleep(5 * time.Second)
 // }
+
+func newFakeRotation(open func() (io.ReadCloser, error)) *STFRotation {
+	r := NewSTFRotation(nil, nil)
+	r.RetryBackoff = time.Millisecond
+	r.prepare = func(context.Context) (string, error) { return "/data/app/base.apk", nil }
+	r.open = func(string) (io.ReadCloser, error) { return open() }
+	return r
+}
+
+func TestRotationRetryExhausted(t *testing.T) {
+	opened := 0
+	r := newFakeRotation(func() (io.ReadCloser, error) {
+		opened++
+		return nil, errors.New("no device")
+	})
+	r.MaxRetry = 2
+	subC := r.Subscribe()
+	assert.NoError(t, r.Start())
+	_, ok := <-subC
+	assert.False(t, ok, "subscribers are closed when give up")
+	err := r.Wait()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no device")
+	assert.Equal(t, 2, opened)
+	assert.Error(t, r.Stop())
+}
+
+func TestRotationRestart(t *testing.T) {
+	var mu sync.Mutex
+	var writers []*io.PipeWriter
+	r := newFakeRotation(func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		mu.Lock()
+		writers = append(writers, pw)
+		mu.Unlock()
+		go pw.Write([]byte("90\n"))
+		return pr, nil
+	})
+	for i := 0; i < 2; i++ {
+		subC := r.Subscribe()
+		assert.NoError(t, r.Start())
+		assert.Equal(t, 90, <-subC)
+		v, err := r.Rotation()
+		assert.NoError(t, err)
+		assert.Equal(t, 90, v)
+		assert.NoError(t, r.Stop())
+		_, ok := <-subC
+		assert.False(t, ok)
+		_, err = r.Rotation()
+		assert.Error(t, err)
+	}
+	assert.Equal(t, ErrServiceNotStarted, r.Stop())
+}
+
+// stuckReader ignore Close, like an adb connection which hangs
+type stuckReader struct{ C chan bool }
+
+func (r stuckReader) Read(p []byte) (int, error) { <-r.C; return 0, io.EOF }
+func (r stuckReader) Close() error               { return nil }
+
+func TestRotationStopContext(t *testing.T) {
+	stuck := stuckReader{make(chan bool)}
+	defer close(stuck.C)
+	openedC := make(chan bool, 1)
+	r := newFakeRotation(func() (io.ReadCloser, error) {
+		openedC <- true
+		return stuck, nil
+	})
+	assert.NoError(t, r.Start())
+	<-openedC
+	time.Sleep(10 * time.Millisecond) // let watcher block on reading
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := r.StopContext(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "force stop rotation")
+	}
+}
+
+func TestRotationRestartAfterForceStop(t *testing.T) {
+	stuck := stuckReader{make(chan bool)}
+	openedC := make(chan bool, 2)
+	first := true
+	r := newFakeRotation(func() (io.ReadCloser, error) {
+		openedC <- true
+		if first {
+			first = false
+			return stuck, nil
+		}
+		pr, pw := io.Pipe()
+		go pw.Write([]byte("90\n"))
+		return pr, nil
+	})
+	assert.NoError(t, r.Start())
+	<-openedC
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, r.StopContext(ctx))
+
+	subC := r.Subscribe()
+	assert.NoError(t, r.Start())
+	assert.Equal(t, 90, <-subC)
+	close(stuck.C) // the force stopped watcher quit at last
+	select {
+	case _, ok := <-subC:
+		assert.True(t, ok, "subscriber of the new run is closed")
+	case <-GoFunc(r.Wait):
+		t.Fatal("new run is completed by the old one")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, r.Stop())
+}
+
+func TestRotationFailedStart(t *testing.T) {
+	r := newFakeRotation(nil)
+	r.prepare = func(context.Context) (string, error) { return "", errors.New("install failed") }
+	assert.EqualError(t, r.Start(), "install failed")
+	assert.EqualError(t, r.Wait(), "install failed")
+}
@@ -0,0 +1,77 @@
+package stf
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// rotationHub keep the latest rotation and fan out changes to subscribers.
+// RotationWatcher implementations share it for Rotation, Subscribe and Unsubscribe.
+type rotationHub struct {
+	mu          sync.Mutex
+	lastValue   int
+	subscribers map[chan int]bool
+}
+
+func newRotationHub() *rotationHub {
+	return &rotationHub{
+		lastValue:   -1,
+		subscribers: make(map[chan int]bool),
+	}
+}
+
+// 0, 90, 180, 270
+func (h *rotationHub) Rotation() (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastValue == -1 {
+		return 0, errors.New("Rotation not ready")
+	}
+	return h.lastValue, nil
+}
+
+func (h *rotationHub) Subscribe() chan int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	C := make(chan int, 1)
+	h.subscribers[C] = true
+	return C
+}
+
+// unsubscribe will also close channel
+func (h *rotationHub) Unsubscribe(C chan int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[C] {
+		delete(h.subscribers, C)
+		close(C)
+	}
+}
+
+// pub drop subscribers which can not receive in 1 second
+func (h *rotationHub) pub(v int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastValue = v
+	for subC := range h.subscribers {
+		select {
+		case subC <- v:
+		case <-time.After(1 * time.Second):
+			delete(h.subscribers, subC)
+			close(subC)
+		}
+	}
+}
+
+// close is called when watcher quit, all subscribers will be closed
+func (h *rotationHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for subC := range h.subscribers {
+		delete(h.subscribers, subC)
+		close(subC)
+	}
+	h.lastValue = -1
+}
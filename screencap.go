@@ -0,0 +1,211 @@
+package stf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	adb "github.com/openatx/go-adb"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultScreencapInterval = 500 * time.Millisecond
+	defaultScreencapQuality  = 80
+	screencapMaxFailures     = 3
+)
+
+// android PixelFormat used by raw screencap output
+const (
+	PIXEL_FORMAT_RGBA_8888 = 1
+	PIXEL_FORMAT_RGBX_8888 = 2
+	PIXEL_FORMAT_BGRA_8888 = 5
+)
+
+var (
+	_ ScreenReader = (*ScreencapCapturer)(nil)
+	_ FrameSource  = (*ScreencapCapturer)(nil)
+)
+
+// ScreencapCapturer capture screen by running screencap in a loop.
+// It is much slower than minicap, but works on every android version.
+type ScreencapCapturer struct {
+	// Raw use raw screencap output instead of png,
+	// it cost less cpu on device but more bandwidth
+	Raw bool
+	// Quality is the jpeg quality of frames
+	Quality int
+
+	interval int64 // time.Duration
+	rotation int32
+	quitC    chan bool
+
+	*adb.Device
+	*frameHub
+	errorMixin
+	safeMixin
+}
+
+func NewScreencapCapturer(device *adb.Device) *ScreencapCapturer {
+	return newScreencapCapturer(device, newFrameHub())
+}
+
+func newScreencapCapturer(device *adb.Device, hub *frameHub) *ScreencapCapturer {
+	return &ScreencapCapturer{
+		Quality:  defaultScreencapQuality,
+		interval: int64(defaultScreencapInterval),
+		Device:   device,
+		frameHub: hub,
+	}
+}
+
+// SetInterval set minimum interval between two captures
+func (s *ScreencapCapturer) SetInterval(d time.Duration) {
+	if d <= 0 {
+		d = defaultScreencapInterval
+	}
+	atomic.StoreInt64(&s.interval, int64(d))
+}
+
+// SetRotation set Rotation of following frames, screencap output is already rotated
+func (s *ScreencapCapturer) SetRotation(r int) {
+	atomic.StoreInt32(&s.rotation, int32(r))
+}
+
+func (s *ScreencapCapturer) Start() error {
+	return s.safeDo(_ACTION_START, func() error {
+		s.resetError()
+		s.watchState(s.Wait)
+		s.quitC = make(chan bool, 1)
+		s.frameHub.open()
+		go s.captureLoop()
+		return nil
+	})
+}
+
+func (s *ScreencapCapturer) Stop() error {
+	return s.safeDo(_ACTION_STOP, func() error {
+		s.quitC <- true
+		return s.Wait()
+	})
+}
+
+func (s *ScreencapCapturer) captureLoop() {
+	var err error
+	defer func() {
+		s.frameHub.close()
+		s.doneError(errors.Wrap(err, "screencap"))
+	}()
+	failures := 0
+	for {
+		start := time.Now()
+		var f *Frame
+		f, err = s.capture()
+		if err == nil {
+			failures = 0
+			s.frameHub.pub(f)
+			s.recovered()
+		} else if failures++; failures >= screencapMaxFailures {
+			return
+		} else {
+			s.restarting(err)
+		}
+		wait := time.Duration(atomic.LoadInt64(&s.interval)) - time.Since(start)
+		select {
+		case <-s.quitC:
+			err = nil
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *ScreencapCapturer) capture() (*Frame, error) {
+	args := []string{"-p"}
+	if s.Raw {
+		args = nil
+	}
+	c, err := s.OpenCommand("screencap", args...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(c)
+	c.Close()
+	if err != nil {
+		return nil, err
+	}
+	var img image.Image
+	if s.Raw {
+		img, err = decodeRawScreencap(data)
+	} else {
+		img, err = decodePngScreencap(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: s.Quality}); err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	return &Frame{
+		Time:          time.Now(),
+		Size:          buf.Len(),
+		RealWidth:     bounds.Dx(),
+		RealHeight:    bounds.Dy(),
+		VirtualWidth:  bounds.Dx(),
+		VirtualHeight: bounds.Dy(),
+		Rotation:      int(atomic.LoadInt32(&s.rotation)),
+		Data:          buf.Bytes(),
+	}, nil
+}
+
+// old adb shell (before android 7) convert \n to \r\n
+func decodePngScreencap(data []byte) (image.Image, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err == nil {
+		return img, nil
+	}
+	return png.Decode(bytes.NewReader(bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)))
+}
+
+// decodeRawScreencap decode output of screencap without -p
+// header: width(4) height(4) format(4) [colorspace(4) since android 9]
+func decodeRawScreencap(data []byte) (image.Image, error) {
+	if len(data) < 12 {
+		return nil, errors.New("raw screencap too short")
+	}
+	width := int(binary.LittleEndian.Uint32(data[0:4]))
+	height := int(binary.LittleEndian.Uint32(data[4:8]))
+	format := binary.LittleEndian.Uint32(data[8:12])
+	size := width * height * 4
+	var pixels []byte
+	switch len(data) - size {
+	case 12, 16:
+		pixels = data[len(data)-size:]
+	default:
+		return nil, errors.Errorf("raw screencap size mismatch, %dx%d got %d bytes", width, height, len(data))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	switch format {
+	case PIXEL_FORMAT_RGBA_8888:
+		copy(img.Pix, pixels)
+	case PIXEL_FORMAT_RGBX_8888:
+		copy(img.Pix, pixels)
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 0xff
+		}
+	case PIXEL_FORMAT_BGRA_8888:
+		for i := 0; i < size; i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = pixels[i+2], pixels[i+1], pixels[i], pixels[i+3]
+		}
+	default:
+		return nil, errors.Errorf("unsupported raw screencap pixel format %d", format)
+	}
+	return img, nil
+}
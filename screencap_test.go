@@ -0,0 +1,43 @@
+package stf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeRawScreencap(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.LittleEndian, []uint32{2, 1, PIXEL_FORMAT_BGRA_8888, 0})
+	buf.Write([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	img, err := decodeRawScreencap(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), img.Bounds())
+	assert.Equal(t, color.RGBA{3, 2, 1, 4}, img.At(0, 0))
+
+	buf.Reset()
+	binary.Write(buf, binary.LittleEndian, []uint32{1, 1, PIXEL_FORMAT_RGBX_8888})
+	buf.Write([]byte{1, 2, 3, 0})
+	img, err = decodeRawScreencap(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, color.RGBA{1, 2, 3, 255}, img.At(0, 0))
+
+	_, err = decodeRawScreencap(buf.Bytes()[:14])
+	assert.Error(t, err)
+}
+
+func TestDecodePngScreencap(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 3, 2)))
+	mangled := bytes.Replace(buf.Bytes(), []byte("\n"), []byte("\r\n"), -1)
+	for _, data := range [][]byte{buf.Bytes(), mangled} {
+		img, err := decodePngScreencap(data)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, img.Bounds().Dx())
+	}
+}
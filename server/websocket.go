@@ -0,0 +1,211 @@
+// Package server expose device screen and touch through websocket,
+// compatible with STF web UI and minicap/minitouch example clients.
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	stf "github.com/openatx/go-stf"
+)
+
+// RawToucher accept minitouch protocol commands, STFTouch is a RawToucher
+type RawToucher interface {
+	SendRaw(cmds string) error
+}
+
+// Resizer can change output frame size at runtime, used by "size WxH" message
+type Resizer interface {
+	SetMaxSize(width, height int) error
+}
+
+var (
+	_ RawToucher = (*stf.STFTouch)(nil)
+	_ Resizer    = (*stf.STFCapturer)(nil)
+)
+
+const writeTimeout = 10 * time.Second
+
+// WSServer bridge screen and touch to one websocket
+//
+// Server to client: binary message, each one is a jpeg frame;
+// text message "rotation 90" when device rotated
+//
+// Client to server (text message):
+//
+//	on            start sending frames (default)
+//	off           stop sending frames
+//	size 720x1280 ask screen source for a new max size
+//	d/m/u/c/r/w   minitouch commands, one per line, see https://github.com/openstf/minitouch
+//
+// Cross origin requests are rejected by default, use AllowOrigins to permit them
+type WSServer struct {
+	screen   stf.FrameSource
+	touch    RawToucher
+	Upgrader websocket.Upgrader
+}
+
+// NewWSServer create server, touch can be nil if only screen is needed
+func NewWSServer(screen stf.FrameSource, touch RawToucher) *WSServer {
+	return &WSServer{
+		screen: screen,
+		touch:  touch,
+	}
+}
+
+// AllowOrigins permit websocket from other origins, eg: "http://localhost:3000",
+// "*" permit any origin. Same origin requests are always allowed
+func (s *WSServer) AllowOrigins(origins ...string) {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	s.Upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowed["*"] || allowed[strings.ToLower(origin)] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+func (s *WSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var streaming int32 = 1
+	quitC := make(chan bool)
+	defer close(quitC)
+	go s.writeFrames(conn, &streaming, quitC)
+
+	for {
+		mtype, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if mtype != websocket.TextMessage {
+			continue
+		}
+		s.handleMessage(string(data), &streaming)
+	}
+}
+
+func (s *WSServer) handleMessage(msg string, streaming *int32) {
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return
+	}
+	switch fields[0] {
+	case "on":
+		atomic.StoreInt32(streaming, 1)
+	case "off":
+		atomic.StoreInt32(streaming, 0)
+	case "size":
+		if len(fields) < 2 {
+			return
+		}
+		width, height, ok := parseSize(fields[1])
+		if !ok {
+			return
+		}
+		if rs, ok := s.screen.(Resizer); ok {
+			rs.SetMaxSize(width, height)
+		}
+	default:
+		if s.touch == nil {
+			return
+		}
+		if cmds := filterTouchCommands(msg); cmds != "" {
+			s.touch.SendRaw(cmds)
+		}
+	}
+}
+
+// writeFrames is the only goroutine write to conn
+func (s *WSServer) writeFrames(conn *websocket.Conn, streaming *int32, quitC chan bool) {
+	sub := s.screen.SubscribeFrames(stf.LatestOnly, 1)
+	defer s.screen.UnsubscribeFrames(sub)
+	defer conn.Close() // wake up reader if write failed
+	for {
+		select {
+		case f, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if atomic.LoadInt32(streaming) == 0 {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			msgType, data := websocket.BinaryMessage, f.Data
+			if f.Event == stf.FRAME_ROTATION {
+				msgType, data = websocket.TextMessage, []byte("rotation "+strconv.Itoa(f.Rotation))
+			}
+			if err := conn.WriteMessage(msgType, data); err != nil {
+				return
+			}
+		case <-quitC:
+			return
+		}
+	}
+}
+
+func parseSize(s string) (width, height int, ok bool) {
+	parts := strings.SplitN(s, "x", 2)
+	if len(parts) != 2 {
+		return
+	}
+	width, err1 := strconv.Atoi(parts[0])
+	height, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// filterTouchCommands drop lines not in minitouch protocol
+func filterTouchCommands(msg string) string {
+	var lines []string
+	for _, line := range strings.Split(msg, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !validTouchCommand(fields) {
+			continue
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validTouchCommand check command name and argument count, all arguments are non-negative integers
+//
+//	c | r | w <ms> | u <contact> | d/m <contact> <x> <y> <pressure>
+func validTouchCommand(fields []string) bool {
+	var nargs int
+	switch fields[0] {
+	case "c", "r":
+		nargs = 0
+	case "w", "u":
+		nargs = 1
+	case "d", "m":
+		nargs = 4
+	default:
+		return false
+	}
+	if len(fields) != nargs+1 {
+		return false
+	}
+	for _, arg := range fields[1:] {
+		if n, err := strconv.Atoi(arg); err != nil || n < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	stf "github.com/openatx/go-stf"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeScreen struct {
+	*stf.Broadcaster
+	width, height int
+}
+
+func (s *fakeScreen) SubscribeFrames(policy stf.BackpressurePolicy, size int) *stf.Subscription {
+	return s.Subscribe(policy, size)
+}
+
+func (s *fakeScreen) UnsubscribeFrames(sub *stf.Subscription) {
+	s.Unsubscribe(sub)
+}
+
+func (s *fakeScreen) LastFrame() (*stf.Frame, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeScreen) SetMaxSize(width, height int) error {
+	s.width, s.height = width, height
+	return nil
+}
+
+type fakeTouch struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (t *fakeTouch) SendRaw(cmds string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cmds = append(t.cmds, cmds)
+	return nil
+}
+
+func (t *fakeTouch) Commands() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.cmds...)
+}
+
+func TestWSServer(t *testing.T) {
+	screen := &fakeScreen{Broadcaster: stf.NewBroadcaster()}
+	touch := &fakeTouch{}
+	ts := httptest.NewServer(NewWSServer(screen, touch))
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for screen.Len() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	screen.Publish(&stf.Frame{Data: []byte("\xff\xd8jpeg")})
+	mtype, data, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, websocket.BinaryMessage, mtype)
+	assert.Equal(t, "\xff\xd8jpeg", string(data))
+
+	conn.WriteMessage(websocket.TextMessage, []byte("size 360x640"))
+	conn.WriteMessage(websocket.TextMessage, []byte("d 0 10 20 50\nc\nbad\nu 0\nc\n"))
+	for i := 0; i < 100 && len(touch.Commands()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"d 0 10 20 50\nc\nu 0\nc"}, touch.Commands())
+	assert.Equal(t, 360, screen.width)
+	assert.Equal(t, 640, screen.height)
+}
+
+func TestFilterTouchCommands(t *testing.T) {
+	assert.Equal(t, "d 0 10 20 50\nm 0 11 21 50\nc\nw 10\nu 0\nr",
+		filterTouchCommands("d 0 10 20 50\n  m 0  11 21 50 \nc\nw 10\nu 0\nr\n"))
+	assert.Equal(t, "", filterTouchCommands("down 0 1 2 3\nd 0 1 2\nd 0 1 2 3 4\nu\nc 1\nm 0 -1 2 3\nw abc\nd 0 1e3 2 3"))
+}
+
+func TestWSServerOrigin(t *testing.T) {
+	s := NewWSServer(&fakeScreen{Broadcaster: stf.NewBroadcaster()}, nil)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	dial := func(origin string) error {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {origin}})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	assert.NoError(t, dial(ts.URL))
+	assert.Error(t, dial("http://evil.example.com"))
+
+	s.AllowOrigins("http://localhost:3000/")
+	assert.NoError(t, dial("http://localhost:3000"))
+	assert.Error(t, dial("http://evil.example.com"))
+
+	s.AllowOrigins("*")
+	assert.NoError(t, dial("http://evil.example.com"))
+}
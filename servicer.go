@@ -1,8 +1,12 @@
 package stf
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -16,42 +20,234 @@ type Servicer interface {
 	Wait() error
 }
 
+// ContextServicer accept context for start and stop.
+// Cancelling ctx of StartContext abort pushes and dials in progress,
+// deadline of StopContext force kill the processes running on device.
+type ContextServicer interface {
+	Servicer
+	StartContext(ctx context.Context) error
+	StopContext(ctx context.Context) error
+}
+
+// Stop of ContextServicer give StopContext this much time
+const defaultStopTimeout = 10 * time.Second
+
+func stopWithTimeout(stop func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultStopTimeout)
+	defer cancel()
+	return stop(ctx)
+}
+
+// waitContext return result of wait, force is called when ctx done first,
+// force must make wait return
+func waitContext(ctx context.Context, wait func() error, force func(err error)) error {
+	errC := make(chan error, 1)
+	go func() {
+		errC <- wait()
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		force(ctx.Err())
+		return <-errC
+	}
+}
+
+// ServiceError tell which servicer of MultiServicer failed
+type ServiceError struct {
+	Name string
+	Err  error
+}
+
+func (e ServiceError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+// MultiServiceError is returned by MultiServicer, errors are in the order they happened,
+// so the first one is the first failure
+type MultiServiceError struct {
+	Errors []ServiceError
+}
+
+// First return the servicer which failed first
+func (e *MultiServiceError) First() ServiceError {
+	return e.Errors[0]
+}
+
+func (e *MultiServiceError) Error() string {
+	errStrs := make([]string, 0, len(e.Errors))
+	for _, se := range e.Errors {
+		errStrs = append(errStrs, se.Error())
+	}
+	return strings.Join(errStrs, "; ")
+}
+
+func (e *MultiServiceError) add(name string, err error) {
+	if err != nil {
+		e.Errors = append(e.Errors, ServiceError{name, err})
+	}
+}
+
+func (e *MultiServiceError) errorOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+type namedServicer struct {
+	Servicer
+	name string
+	deps []string
+}
+
+// Named give servicer a name used in errors of MultiServicer,
+// deps are names of servicers which must be started before it
+func Named(name string, s Servicer, deps ...string) Servicer {
+	return &namedServicer{s, name, deps}
+}
+
+func serviceName(index int, s Servicer) string {
+	if ns, ok := s.(*namedServicer); ok {
+		return ns.name
+	}
+	return fmt.Sprintf("#%d(%T)", index, s)
+}
+
 type multiServ struct {
-	ss []Servicer
+	ss       []Servicer
+	mu       sync.Mutex
+	started  []Servicer
+	names    map[Servicer]string
+	stopOnce *sync.Once
+	stopErr  error
+
+	errorMixin
+	safeMixin
 }
 
+// order sort servicers by dependencies, servicers without dependencies keep their order
+func (m *multiServ) order() ([]Servicer, error) {
+	m.names = make(map[Servicer]string, len(m.ss))
+	placed := make(map[string]bool)
+	for i, s := range m.ss {
+		m.names[s] = serviceName(i, s)
+	}
+	ordered := make([]Servicer, 0, len(m.ss))
+	for len(ordered) < len(m.ss) {
+		progress := false
+		for _, s := range m.ss {
+			name := m.names[s]
+			if placed[name] {
+				continue
+			}
+			ready := true
+			if ns, ok := s.(*namedServicer); ok {
+				for _, dep := range ns.deps {
+					ready = ready && placed[dep]
+				}
+			}
+			if ready {
+				placed[name] = true
+				ordered = append(ordered, s)
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, errors.New("servicer dependencies are missing or circular")
+		}
+	}
+	return ordered, nil
+}
+
+// Start in dependency order, started ones are stopped in reverse order if any of them failed
 func (m *multiServ) Start() error {
-	for _, s := range m.ss {
-		if err := s.Start(); err != nil {
+	return m.safeDo(_ACTION_START, func() error {
+		m.resetError()
+		m.watchState(m.Wait)
+		ordered, err := m.order()
+		if err != nil {
+			m.doneError(err)
 			return err
 		}
-	}
-	return nil
+		m.mu.Lock()
+		m.started = nil
+		m.stopOnce = &sync.Once{}
+		m.mu.Unlock()
+		for _, s := range ordered {
+			if err := s.Start(); err != nil {
+				merr := &MultiServiceError{}
+				merr.add(m.names[s], err)
+				m.mu.Lock()
+				started := m.started
+				m.started = nil
+				m.mu.Unlock()
+				for i := len(started) - 1; i >= 0; i-- {
+					merr.add(m.names[started[i]], started[i].Stop())
+				}
+				m.doneError(merr)
+				return merr
+			}
+			m.mu.Lock()
+			m.started = append(m.started, s)
+			m.mu.Unlock()
+		}
+		m.mu.Lock()
+		started := m.started
+		m.mu.Unlock()
+		go m.watch(started)
+		return nil
+	})
 }
 
+// Stop in reverse order of start
 func (m *multiServ) Stop() error {
-	var err error
-	for _, s := range m.ss {
-		if er := s.Stop(); er != nil {
-			err = er
+	return m.safeDo(_ACTION_STOP, func() error {
+		err := m.stopAll()
+		m.Wait()
+		return err
+	})
+}
+
+func (m *multiServ) stopAll() error {
+	m.mu.Lock()
+	once, started := m.stopOnce, m.started
+	m.mu.Unlock()
+	once.Do(func() {
+		merr := &MultiServiceError{}
+		for i := len(started) - 1; i >= 0; i-- {
+			merr.add(m.names[started[i]], started[i].Stop())
 		}
-	}
-	return err
+		m.stopErr = merr.errorOrNil()
+	})
+	return m.stopErr
 }
 
-func (m *multiServ) Wait() error {
-	errC := make(chan error, len(m.ss))
-	for _, s := range m.ss {
+// watch stop all servicers once any of them quit,
+// Wait return after all of them quit
+func (m *multiServ) watch(started []Servicer) {
+	quitC := make(chan ServiceError, len(started))
+	for _, s := range started {
 		go func(s Servicer) {
-			errC <- s.Wait()
+			quitC <- ServiceError{m.names[s], s.Wait()}
 		}(s)
 	}
-	return <-errC
+	merr := &MultiServiceError{}
+	for range started {
+		se := <-quitC
+		merr.add(se.Name, se.Err)
+		go m.stopAll()
+	}
+	m.doneError(merr.errorOrNil())
 }
 
-// Combine servicers into one servicer
+// MultiServicer combine servicers into one, they are started in the given order
+// unless dependencies are declared with Named.
+// When any of them quit, the others are stopped too,
+// and Wait return a *MultiServiceError with the first failure at the beginning.
 func MultiServicer(ss ...Servicer) Servicer {
-	return &multiServ{ss}
+	return &multiServ{ss: ss}
 }
 
 // Mutex
@@ -61,108 +257,132 @@ const (
 )
 
 type safeMixin struct {
-	mu      sync.Mutex
+	mu      sync.Mutex // serialize start and stop
+	smu     sync.Mutex // protect fields below
 	started bool
+	state   ServiceState
+	gen     int // changed by every start and stop
+	wait    func() error
+	subs    map[chan StateEvent]bool
 }
 
 func (t *safeMixin) safeDo(action int, f func() error) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	if t.started && action == _ACTION_START {
+	t.smu.Lock()
+	started, failed := t.started, t.state == STATE_FAILED
+	t.smu.Unlock()
+	if started && action == _ACTION_START {
 		return ErrServiceAlreadyStarted
 	}
-	if !t.started && action == _ACTION_STOP {
+	if !started && action == _ACTION_STOP {
 		return ErrServiceNotStarted
 	}
-	t.started = (action == _ACTION_START)
-	return f()
+	if action == _ACTION_START {
+		t.setState(STATE_PREPARING, nil)
+		err := f()
+		if err != nil { // a failed start leaves service stopped
+			t.setState(STATE_FAILED, err)
+			return err
+		}
+		t.smu.Lock()
+		t.started = true
+		t.gen++
+		gen, wait := t.gen, t.wait
+		t.smu.Unlock()
+		t.setState(STATE_RUNNING, nil)
+		if wait != nil {
+			go t.waitQuit(gen, wait)
+		}
+		return nil
+	}
+	// keep failed state, it is more useful than stopped
+	if !failed {
+		t.setState(STATE_STOPPING, nil)
+	}
+	err := f()
+	t.smu.Lock()
+	t.started = false
+	t.gen++
+	t.smu.Unlock()
+	if !failed {
+		t.setState(STATE_STOPPED, err)
+	}
+	return err
 }
 
 func (t *safeMixin) IsStarted() bool {
+	t.smu.Lock()
+	defer t.smu.Unlock()
 	return t.started
 }
 
-// Mutex retry
-// type safeErrorMixin struct {
-// 	safeMixin
-// 	errorMixin
-// 	errC      chan error
-// 	maxRetry  int
-// 	f         func() error
-// 	startFunc func() error
-// 	stopFunc  func() error
-// }
-
-// func (t *safeErrorMixin) safeRetryDo(maxRetry int, dur time.Duration,
-// 	startFunc func() error, stopFunc func() error) error {
-// 	return t.safeDo(action, func() error {
-// 		t.maxRetry = maxRetry
-// 		t.startFunc = startFunc
-// 		t.stopFunc = stopFunc
-// 		t.errC = make(chan error, 1)
-// 		return t.doWithRetry()
-// 	})
-// }
-
-// func (t *safeErrorMixin) doWithRetry() error {
-// 	if err := t.startFunc(); err != nil {
-// 		return err
-// 	}
-// 	go func() {
-// 		leftRetry := t.maxRetry
-// 		for leftRetry > 0 {
-// 			startTime := time.Now()
-// 			err := t.Wait()
-// 			if err != nil {
-// 				leftRetry -= 1
-// 				if time.Since(startTime) > 20*time.Second {
-// 					leftRetry = t.maxRetry
-// 				}
-// 				t.stopFunc()
-// 				// t.Stop()
-// 			}
-// 		}
-// 	}()
-// }
-
-// // if exit retry again
-// func (t *safeErrorMixin) Wait() error {
-// 	err := t.errorMixin.Wait()
-// 	// errC := GoFunc(t.errorMixin.Wait)
-// 	// select {
-// 	// case <- errC:
-// 	// }
-// 	return err
-// }
-
-// func (t *mutexRetryMixin) Wait() error {
-// }
-
 // Mixin helper to easy write Servicer
 type errorMixin struct {
 	errC chan error
-	once *sync.Once
-	wg   *sync.WaitGroup
-	err  error
+	emu  sync.Mutex
+	run  *errorRun
+}
+
+// errorRun is the result of one run, every resetError create a new one,
+// so goroutines left by a forced stop can not complete the next run
+type errorRun struct {
+	once  sync.Once
+	doneC chan bool
+	err   error
+}
+
+// done set result of this run, only the first call works
+func (r *errorRun) done(err error) {
+	r.doneWith(err, nil)
+}
+
+// doneWith run cleanup before result is set, only when this call is the first one
+func (r *errorRun) doneWith(err error, cleanup func()) {
+	r.once.Do(func() {
+		if cleanup != nil {
+			cleanup()
+		}
+		r.err = err
+		close(r.doneC)
+	})
+}
+
+func (r *errorRun) wait() error {
+	<-r.doneC
+	return r.err
+}
+
+// this func must be called before use other functions,
+// goroutines of the run should complete it with the returned errorRun
+func (e *errorMixin) resetError() *errorRun {
+	run := &errorRun{doneC: make(chan bool)}
+	e.emu.Lock()
+	e.run = run
+	e.emu.Unlock()
+	return run
 }
 
-// this func must be called before use other functions
-func (e *errorMixin) resetError() {
-	e.once = &sync.Once{}
-	e.wg = &sync.WaitGroup{}
-	e.wg.Add(1)
+func (e *errorMixin) currentRun() *errorRun {
+	e.emu.Lock()
+	defer e.emu.Unlock()
+	return e.run
 }
 
+// Wait return ErrServiceNotStarted if never started
 func (e *errorMixin) Wait() error {
-	e.wg.Wait()
-	return e.err
+	run := e.currentRun()
+	if run == nil {
+		return ErrServiceNotStarted
+	}
+	return run.wait()
 }
 
+// doneError complete the current run
 func (e *errorMixin) doneError(err error) {
-	e.once.Do(func() {
-		e.err = err
-		e.wg.Done()
-	})
+	if run := e.currentRun(); run != nil {
+		run.done(err)
+	}
 }
 
 func (e *errorMixin) doneNilError() {
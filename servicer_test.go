@@ -0,0 +1,124 @@
+package stf
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitContext(t *testing.T) {
+	err := waitContext(context.Background(), func() error {
+		return errors.New("quit")
+	}, func(error) {
+		t.Fatal("force should not be called")
+	})
+	assert.EqualError(t, err, "quit")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	killC := make(chan error, 1)
+	err = waitContext(ctx, func() error {
+		return <-killC
+	}, func(err error) {
+		killC <- errors.Wrap(err, "killed")
+	})
+	assert.EqualError(t, err, "killed: "+context.DeadlineExceeded.Error())
+}
+
+func TestSafeMixinFailedStart(t *testing.T) {
+	var m safeMixin
+	assert.Error(t, m.safeDo(_ACTION_START, func() error { return errors.New("fail") }))
+	assert.False(t, m.IsStarted())
+	assert.NoError(t, m.safeDo(_ACTION_START, func() error { return nil }))
+	assert.Equal(t, ErrServiceAlreadyStarted, m.safeDo(_ACTION_START, func() error { return nil }))
+	assert.NoError(t, m.safeDo(_ACTION_STOP, func() error { return nil }))
+	assert.Equal(t, ErrServiceNotStarted, m.safeDo(_ACTION_STOP, func() error { return nil }))
+}
+
+func TestErrorMixinStaleRun(t *testing.T) {
+	var e errorMixin
+	assert.Equal(t, ErrServiceNotStarted, e.Wait())
+	old := e.resetError()
+	e.doneError(errors.New("force stopped"))
+	assert.EqualError(t, e.Wait(), "force stopped")
+
+	e.resetError()
+	old.done(errors.New("late")) // goroutine of the last run quit
+	select {
+	case <-GoFunc(e.Wait):
+		t.Fatal("stale run completed the current one")
+	case <-time.After(10 * time.Millisecond):
+	}
+	e.doneError(nil)
+	assert.NoError(t, e.Wait())
+}
+
+// orderServicer record start and stop order
+type orderServicer struct {
+	flakyServicer
+	name string
+	log  *[]string
+}
+
+func (o *orderServicer) Start() error {
+	*o.log = append(*o.log, "start "+o.name)
+	return o.flakyServicer.Start()
+}
+
+func (o *orderServicer) Stop() error {
+	*o.log = append(*o.log, "stop "+o.name)
+	return o.flakyServicer.Stop()
+}
+
+func TestMultiServicerOrder(t *testing.T) {
+	var log []string
+	a := &orderServicer{name: "a", log: &log}
+	b := &orderServicer{name: "b", log: &log}
+	c := &orderServicer{name: "c", log: &log}
+	m := MultiServicer(Named("a", a, "c"), Named("b", b), Named("c", c, "b"))
+	assert.NoError(t, m.Start())
+	assert.NoError(t, m.Stop())
+	assert.NoError(t, m.Wait())
+	assert.Equal(t, []string{"start b", "start c", "start a", "stop a", "stop c", "stop b"}, log)
+
+	m = MultiServicer(Named("a", a, "b"), Named("b", b, "a"))
+	assert.Error(t, m.Start())
+	assert.Error(t, m.Wait())
+}
+
+func TestMultiServicerRollback(t *testing.T) {
+	var log []string
+	a := &orderServicer{name: "a", log: &log}
+	b := &orderServicer{name: "b", log: &log}
+	b.startErr = errors.New("no device")
+	c := &orderServicer{name: "c", log: &log}
+	m := MultiServicer(Named("a", a), Named("b", b), Named("c", c))
+	err := m.Start()
+	assert.Error(t, err)
+	merr, ok := err.(*MultiServiceError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "b", merr.First().Name)
+	}
+	assert.Equal(t, []string{"start a", "start b", "stop a"}, log)
+	assert.Equal(t, err, m.Wait())
+	assert.EqualError(t, err, "b: no device")
+	assert.Equal(t, ErrServiceNotStarted, m.Stop())
+}
+
+func TestMultiServicerWait(t *testing.T) {
+	a, b := &flakyServicer{}, &flakyServicer{}
+	m := MultiServicer(a, b)
+	assert.NoError(t, m.Start())
+	b.fail(errors.New("usb disconnected"))
+	err := m.Wait()
+	merr, ok := err.(*MultiServiceError)
+	if assert.True(t, ok, err) {
+		assert.Len(t, merr.Errors, 1, "a is stopped without error")
+		assert.Equal(t, "#1(*stf.flakyServicer)", merr.First().Name)
+		assert.EqualError(t, merr.First().Err, "usb disconnected")
+	}
+	assert.NoError(t, m.Stop())
+}
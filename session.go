@@ -0,0 +1,136 @@
+package stf
+
+import (
+	"sync"
+
+	adb "github.com/openatx/go-adb"
+)
+
+// rotatable is implemented by capturers and touchers which need device rotation
+type rotatable interface {
+	SetRotation(r int)
+}
+
+// Session compose screen capture, touch and rotation watcher of one device.
+// Every rotation change is pushed to screen and touch,
+// and a FRAME_ROTATION frame is put into the frame stream.
+type Session struct {
+	Screen  ScreenReader
+	Touch   Toucher
+	Watcher RotationWatcher
+
+	mu       sync.Mutex
+	rotation int
+	quitC    chan bool
+	loopDone chan bool
+	members  Servicer // MultiServicer of Watcher, Screen and Touch
+
+	errorMixin
+	safeMixin
+}
+
+// NewSession use minicap (or screencap), minitouch (or adb input) and RotationWatcher.apk,
+// provider can be nil to use DefaultBinaryProvider
+func NewSession(device *adb.Device, provider BinaryProvider) *Session {
+	return NewSessionWith(
+		NewSTFCapturer(device, provider),
+		NewAutoToucher(device, provider),
+		NewSTFRotation(device, provider))
+}
+
+func NewSessionWith(screen ScreenReader, touch Toucher, watcher RotationWatcher) *Session {
+	return &Session{
+		Screen:   screen,
+		Touch:    touch,
+		Watcher:  watcher,
+		rotation: -1,
+	}
+}
+
+// SetLogger set logger of screen, touch and watcher, nil means slog.Default()
+func (s *Session) SetLogger(l Logger) {
+	for _, c := range []interface{}{s.Screen, s.Touch, s.Watcher} {
+		if lc, ok := c.(interface{ SetLogger(Logger) }); ok {
+			lc.SetLogger(l)
+		}
+	}
+}
+
+// Start watcher, screen and touch in order, already started ones are stopped if any of them failed.
+// When any of them quit, the others are stopped too.
+func (s *Session) Start() error {
+	return s.safeDo(_ACTION_START, func() error {
+		s.resetError()
+		s.watchState(s.Wait)
+		rotationC := s.Watcher.Subscribe()
+		members := MultiServicer(
+			Named("rotation", s.Watcher),
+			Named("screen", s.Screen, "rotation"),
+			Named("touch", s.Touch, "rotation"))
+		if err := members.Start(); err != nil {
+			s.Watcher.Unsubscribe(rotationC)
+			s.doneError(err)
+			return err
+		}
+		s.members = members
+		s.quitC = make(chan bool)
+		s.loopDone = make(chan bool)
+		go s.propagate(rotationC)
+		go func() {
+			s.doneError(members.Wait())
+		}()
+		return nil
+	})
+}
+
+func (s *Session) Stop() error {
+	return s.safeDo(_ACTION_STOP, func() error {
+		close(s.quitC)
+		<-s.loopDone
+		return s.members.Stop()
+	})
+}
+
+// Rotation return the last rotation pushed to screen and touch, -1 if unknown
+func (s *Session) Rotation() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotation
+}
+
+func (s *Session) propagate(rotationC chan int) {
+	defer close(s.loopDone)
+	for {
+		select {
+		case r, ok := <-rotationC:
+			if !ok {
+				return
+			}
+			s.setRotation(r)
+		case <-s.quitC:
+			s.Watcher.Unsubscribe(rotationC)
+			return
+		}
+	}
+}
+
+func (s *Session) setRotation(r int) {
+	s.mu.Lock()
+	changed := s.rotation != r
+	s.rotation = r
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	if rt, ok := s.Touch.(rotatable); ok {
+		rt.SetRotation(r)
+	}
+	if rt, ok := s.Screen.(rotatable); ok {
+		rt.SetRotation(r)
+	}
+	if hub, ok := s.Screen.(interface {
+		pubRotation(int)
+	}); ok {
+		hub.pubRotation(r)
+	}
+}
@@ -0,0 +1,158 @@
+package stf
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// lifecycle make Wait block until Stop or die, like a real servicer
+type lifecycle struct {
+	lmu   sync.Mutex
+	quitC chan error
+}
+
+func (l *lifecycle) start() {
+	l.lmu.Lock()
+	defer l.lmu.Unlock()
+	l.quitC = make(chan error, 1)
+}
+
+// die make Wait return err
+func (l *lifecycle) die(err error) {
+	l.lmu.Lock()
+	defer l.lmu.Unlock()
+	select {
+	case l.quitC <- err:
+	default:
+	}
+}
+
+func (l *lifecycle) Wait() error {
+	l.lmu.Lock()
+	C := l.quitC
+	l.lmu.Unlock()
+	err := <-C
+	C <- err // for other waiters
+	return err
+}
+
+type fakeWatcher struct {
+	mu       sync.Mutex
+	subs     map[chan int]bool
+	startErr error
+	lifecycle
+}
+
+func (w *fakeWatcher) Start() error {
+	if w.startErr != nil {
+		return w.startErr
+	}
+	w.start()
+	return nil
+}
+
+func (w *fakeWatcher) Stop() error { w.die(nil); return nil }
+
+func (w *fakeWatcher) Rotation() (int, error) { return 0, nil }
+
+func (w *fakeWatcher) Subscribe() chan int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subs == nil {
+		w.subs = make(map[chan int]bool)
+	}
+	C := make(chan int)
+	w.subs[C] = true
+	return C
+}
+
+func (w *fakeWatcher) Unsubscribe(C chan int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.subs, C)
+}
+
+func (w *fakeWatcher) pub(r int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for C := range w.subs {
+		C <- r
+	}
+}
+
+type fakeScreen struct {
+	*frameHub
+	rotations chan int
+	lifecycle
+}
+
+func (s *fakeScreen) Start() error      { s.open(); s.start(); return nil }
+func (s *fakeScreen) Stop() error       { s.close(); s.die(nil); return nil }
+func (s *fakeScreen) SetRotation(r int) { s.rotations <- r }
+
+type rotatingToucher struct {
+	fakeToucher
+	rotations chan int
+	lifecycle
+}
+
+func (t *rotatingToucher) Start() error      { t.start(); return nil }
+func (t *rotatingToucher) Stop() error       { t.die(nil); return nil }
+func (t *rotatingToucher) Wait() error       { return t.lifecycle.Wait() }
+func (t *rotatingToucher) SetRotation(r int) { t.rotations <- r }
+
+func TestSession(t *testing.T) {
+	watcher := &fakeWatcher{}
+	screen := &fakeScreen{frameHub: newFrameHub(), rotations: make(chan int, 10)}
+	touch := &rotatingToucher{rotations: make(chan int, 10)}
+	s := NewSessionWith(screen, touch, watcher)
+	assert.NoError(t, s.Start())
+	sub := screen.SubscribeFrames(DropOldest, 10)
+
+	watcher.pub(90)
+	watcher.pub(90)
+	watcher.pub(0)
+	for _, r := range []int{90, 0} {
+		assert.Equal(t, r, <-touch.rotations)
+		assert.Equal(t, r, <-screen.rotations)
+		select {
+		case f := <-sub.C:
+			assert.Equal(t, FRAME_ROTATION, f.Event)
+			assert.Equal(t, r, f.Rotation)
+		case <-time.After(time.Second):
+			t.Fatal("rotation frame not received")
+		}
+	}
+	assert.Equal(t, 0, s.Rotation())
+	_, err := screen.LastFrame()
+	assert.Error(t, err, "rotation event should not be a frame")
+
+	assert.NoError(t, s.Stop())
+	assert.NoError(t, s.Wait())
+	assert.Len(t, watcher.subs, 0)
+}
+
+func TestSessionWatcherFailed(t *testing.T) {
+	watcher := &fakeWatcher{startErr: errors.New("no apk")}
+	screen := &fakeScreen{frameHub: newFrameHub(), rotations: make(chan int, 10)}
+	touch := &rotatingToucher{}
+	s := NewSessionWith(screen, touch, watcher)
+	assert.Error(t, s.Start())
+	assert.Len(t, watcher.subs, 0)
+	assert.Error(t, s.Wait(), "Wait must not block after a failed start")
+
+	// session quit when watcher died
+	watcher.startErr = nil
+	assert.NoError(t, s.Start())
+	watcher.die(errors.New("app_process killed"))
+	err := s.Wait()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "rotation")
+	assert.False(t, screen.isRunning(), "screen is stopped with the watcher")
+	assert.NoError(t, touch.Wait(), "touch is stopped with the watcher")
+	assert.NoError(t, s.Stop())
+}
@@ -0,0 +1,172 @@
+package stf
+
+import (
+	"time"
+)
+
+// ServiceState is the lifecycle state of a servicer
+type ServiceState int
+
+const (
+	STATE_IDLE       ServiceState = iota // never started
+	STATE_PREPARING                      // pushing binaries, dialing
+	STATE_RUNNING                        // working
+	STATE_RESTARTING                     // lost connection or process, trying to recover
+	STATE_STOPPING                       // Stop is in progress
+	STATE_STOPPED                        // stopped by Stop
+	STATE_FAILED                         // quit by itself or failed to start
+)
+
+func (s ServiceState) String() string {
+	switch s {
+	case STATE_IDLE:
+		return "idle"
+	case STATE_PREPARING:
+		return "preparing"
+	case STATE_RUNNING:
+		return "running"
+	case STATE_RESTARTING:
+		return "restarting"
+	case STATE_STOPPING:
+		return "stopping"
+	case STATE_STOPPED:
+		return "stopped"
+	case STATE_FAILED:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// StateEvent is sent on every state change
+type StateEvent struct {
+	Time  time.Time
+	From  ServiceState
+	To    ServiceState
+	Cause error // why it changed, nil for normal transitions
+}
+
+// StateObserver is implemented by all servicers of this package
+type StateObserver interface {
+	State() ServiceState
+	SubscribeState() chan StateEvent
+	UnsubscribeState(chan StateEvent)
+}
+
+var (
+	_ StateObserver = (*STFCapturer)(nil)
+	_ StateObserver = (*STFTouch)(nil)
+	_ StateObserver = (*STFRotation)(nil)
+	_ StateObserver = (*DumpsysRotation)(nil)
+	_ StateObserver = (*AutoToucher)(nil)
+	_ StateObserver = (*Session)(nil)
+	_ StateObserver = (*Supervisor)(nil)
+)
+
+func (t *safeMixin) State() ServiceState {
+	t.smu.Lock()
+	defer t.smu.Unlock()
+	return t.state
+}
+
+// SubscribeState return a channel of state events, slow receivers lose events
+func (t *safeMixin) SubscribeState() chan StateEvent {
+	t.smu.Lock()
+	defer t.smu.Unlock()
+	if t.subs == nil {
+		t.subs = make(map[chan StateEvent]bool)
+	}
+	C := make(chan StateEvent, 16)
+	t.subs[C] = true
+	return C
+}
+
+// UnsubscribeState will also close channel
+func (t *safeMixin) UnsubscribeState(C chan StateEvent) {
+	t.smu.Lock()
+	defer t.smu.Unlock()
+	if t.subs[C] {
+		delete(t.subs, C)
+		close(C)
+	}
+}
+
+func (t *safeMixin) setState(to ServiceState, cause error) {
+	t.smu.Lock()
+	defer t.smu.Unlock()
+	t.setStateLocked(to, cause)
+}
+
+func (t *safeMixin) setStateLocked(to ServiceState, cause error) {
+	if t.state == to && cause == nil {
+		return
+	}
+	e := StateEvent{Time: time.Now(), From: t.state, To: to, Cause: cause}
+	t.state = to
+	for C := range t.subs {
+		select {
+		case C <- e:
+		default:
+		}
+	}
+}
+
+// watchState let the service become failed when wait return by itself,
+// it is called in Start before service is running
+func (t *safeMixin) watchState(wait func() error) {
+	t.smu.Lock()
+	t.wait = wait
+	t.smu.Unlock()
+}
+
+func (t *safeMixin) waitQuit(gen int, wait func() error) {
+	err := wait()
+	t.smu.Lock()
+	defer t.smu.Unlock()
+	if t.gen != gen || !t.isAlive() {
+		return // stopped or restarted
+	}
+	if err == nil {
+		t.setStateLocked(STATE_STOPPED, nil)
+	} else {
+		t.setStateLocked(STATE_FAILED, err)
+	}
+}
+
+// isAlive must be called with smu held
+func (t *safeMixin) isAlive() bool {
+	return t.state == STATE_RUNNING || t.state == STATE_RESTARTING
+}
+
+// restarting is called when service is recovering from cause
+func (t *safeMixin) restarting(cause error) {
+	t.smu.Lock()
+	defer t.smu.Unlock()
+	if t.isAlive() {
+		t.setStateLocked(STATE_RESTARTING, cause)
+	}
+}
+
+// recovered is called when restarting is done
+func (t *safeMixin) recovered() {
+	t.smu.Lock()
+	defer t.smu.Unlock()
+	if t.state == STATE_RESTARTING {
+		t.setStateLocked(STATE_RUNNING, nil)
+	}
+}
+
+// follow copy restarting and recovering of inner servicer, call the returned func to stop
+func (t *safeMixin) follow(o StateObserver) func() {
+	C := o.SubscribeState()
+	go func() {
+		for e := range C {
+			switch e.To {
+			case STATE_RESTARTING:
+				t.restarting(e.Cause)
+			case STATE_RUNNING:
+				t.recovered()
+			}
+		}
+	}()
+	return func() { o.UnsubscribeState(C) }
+}
@@ -0,0 +1,102 @@
+package stf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// mixinServicer is the smallest servicer built on mixins
+type mixinServicer struct {
+	startErr error
+	errorMixin
+	safeMixin
+}
+
+func (m *mixinServicer) Start() error {
+	return m.safeDo(_ACTION_START, func() error {
+		m.resetError()
+		m.watchState(m.Wait)
+		return m.startErr
+	})
+}
+
+func (m *mixinServicer) Stop() error {
+	return m.safeDo(_ACTION_STOP, func() error {
+		m.doneNilError()
+		return nil
+	})
+}
+
+func nextState(t *testing.T, C chan StateEvent) StateEvent {
+	select {
+	case e := <-C:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("state event timeout")
+	}
+	return StateEvent{}
+}
+
+func TestServiceState(t *testing.T) {
+	m := &mixinServicer{}
+	assert.Equal(t, STATE_IDLE, m.State())
+	C := m.SubscribeState()
+	assert.NoError(t, m.Start())
+	assert.Equal(t, STATE_PREPARING, nextState(t, C).To)
+	e := nextState(t, C)
+	assert.Equal(t, STATE_PREPARING, e.From)
+	assert.Equal(t, STATE_RUNNING, e.To)
+	assert.False(t, e.Time.IsZero())
+
+	m.restarting(errors.New("connection lost"))
+	e = nextState(t, C)
+	assert.Equal(t, STATE_RESTARTING, e.To)
+	assert.EqualError(t, e.Cause, "connection lost")
+	m.recovered()
+	assert.Equal(t, STATE_RUNNING, nextState(t, C).To)
+
+	assert.NoError(t, m.Stop())
+	assert.Equal(t, STATE_STOPPING, nextState(t, C).To)
+	assert.Equal(t, STATE_STOPPED, nextState(t, C).To)
+	m.restarting(errors.New("ignored after stop"))
+	assert.Equal(t, STATE_STOPPED, m.State())
+	m.UnsubscribeState(C)
+	_, ok := <-C
+	assert.False(t, ok)
+}
+
+func TestServiceStateFailed(t *testing.T) {
+	m := &mixinServicer{startErr: errors.New("no device")}
+	assert.Error(t, m.Start())
+	assert.Equal(t, STATE_FAILED, m.State())
+	assert.False(t, m.IsStarted())
+
+	m.startErr = nil
+	C := m.SubscribeState()
+	assert.NoError(t, m.Start())
+	nextState(t, C)
+	nextState(t, C)
+	m.doneError(errors.New("process killed"))
+	e := nextState(t, C)
+	assert.Equal(t, STATE_FAILED, e.To)
+	assert.EqualError(t, e.Cause, "process killed")
+	assert.NoError(t, m.Stop())
+	assert.Equal(t, STATE_FAILED, m.State(), "failed is kept after stop")
+}
+
+func TestServiceStateFollow(t *testing.T) {
+	inner, outer := &mixinServicer{}, &mixinServicer{}
+	assert.NoError(t, inner.Start())
+	assert.NoError(t, outer.Start())
+	unfollow := outer.follow(inner)
+	C := outer.SubscribeState()
+	inner.restarting(errors.New("rotation changed"))
+	assert.Equal(t, STATE_RESTARTING, nextState(t, C).To)
+	inner.recovered()
+	assert.Equal(t, STATE_RUNNING, nextState(t, C).To)
+	unfollow()
+	assert.Equal(t, "restarting", STATE_RESTARTING.String())
+}
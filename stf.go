@@ -14,13 +14,64 @@ type ScreenReader interface {
 	NextImage() (image.Image, error)
 	LastImage() (image.Image, error)
 	Subscribe() (chan image.Image, error)
+	Unsubscribe(chan image.Image)
 }
 
+type TouchAction int
+
+const (
+	TOUCH_DOWN = TouchAction(iota)
+	TOUCH_MOVE
+	TOUCH_UP
+)
+
+func (a TouchAction) String() string {
+	switch a {
+	case TOUCH_DOWN:
+		return "d"
+	case TOUCH_MOVE:
+		return "m"
+	default:
+		return "u"
+	}
+}
+
+// TouchEvent is one contact action, X and Y are percentage of screen size
+type TouchEvent struct {
+	Action   TouchAction
+	Index    int // contact id, start from 0
+	X, Y     float64
+	Pressure float64 // range [0, 1], 0 means default
+}
+
+// Toucher is a multi-touch input device.
+// Coordinates are based on current screen rotation.
 type Toucher interface {
 	Servicer
-	Down(x, y int) error
-	Move(x, y int) error
-	Up() error
+	Down(index int, xP, yP float64) error
+	Move(index int, xP, yP float64) error
+	Up(index int) error
+	DownPx(index int, x, y int) error
+	MovePx(index int, x, y int) error
+	// Size is the range of coordinates used by DownPx and MovePx,
+	// it may differ from screen pixels. (0, 0) if not ready
+	Size() (width, height int)
+	// Do commit all events together
+	Do(events ...TouchEvent) error
+}
+
+// TouchCapabilities describe what a Toucher backend can do, zero value means not ready
+type TouchCapabilities struct {
+	MultiTouch  bool
+	MaxContacts int // 0 means unknown
+	Pressure    bool
+	Move        bool // contact can move between down and up with real time feedback
+}
+
+// CapableToucher is a Toucher which reports its capabilities
+type CapableToucher interface {
+	Toucher
+	Capabilities() TouchCapabilities
 }
 
 type UITester interface {
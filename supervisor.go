@@ -0,0 +1,203 @@
+package stf
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Policy control how Supervisor restart a servicer which quit by itself
+type Policy struct {
+	MaxRestarts int           // restarts allowed before give up, 0 means never restart, <0 means unlimited
+	Backoff     time.Duration // delay before the first restart, doubled for each following restart
+	MaxBackoff  time.Duration // upper limit of delay, 0 means maxRestartDelay
+	Jitter      float64       // 0~1, delay is randomly changed by this fraction
+	ResetAfter  time.Duration // restart counter is reset when servicer run longer than it
+}
+
+// maxRestartDelay is used as MaxBackoff when it is not set
+const maxRestartDelay = time.Hour
+
+var DefaultPolicy = Policy{
+	MaxRestarts: 5,
+	Backoff:     500 * time.Millisecond,
+	MaxBackoff:  30 * time.Second,
+	Jitter:      0.2,
+	ResetAfter:  20 * time.Second,
+}
+
+// delay before the n-th restart, n starts from 1
+func (p Policy) delay(n int) time.Duration {
+	limit := p.MaxBackoff
+	if limit <= 0 {
+		limit = maxRestartDelay
+	}
+	d := p.Backoff
+	for i := 1; i < n && d > 0 && d < limit; i++ {
+		if d > limit/2 { // doubling may overflow
+			d = limit
+			break
+		}
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(float64(d) * p.Jitter * (2*rand.Float64() - 1))
+	}
+	return d
+}
+
+// RestartEvent is sent every time supervised servicer quit by itself
+type RestartEvent struct {
+	Time    time.Time
+	Attempt int           // restarts since last reset, starts from 1
+	Err     error         // why servicer quit or failed to start
+	Delay   time.Duration // wait before restart
+	GaveUp  bool          // no more restart, Supervisor quit with Err
+}
+
+// Supervisor restart a servicer according to Policy, it is also a Servicer.
+// Wait return the last error after give up, or nil when stopped.
+type Supervisor struct {
+	s      Servicer
+	policy Policy
+
+	mu       sync.Mutex
+	running  bool // whether s is started
+	subs     map[chan RestartEvent]bool
+	quitC    chan bool
+	loopDone chan bool
+
+	errorMixin
+	safeMixin
+}
+
+func Supervise(s Servicer, policy Policy) *Supervisor {
+	return &Supervisor{
+		s:      s,
+		policy: policy,
+		subs:   make(map[chan RestartEvent]bool),
+	}
+}
+
+func (sv *Supervisor) Start() error {
+	return sv.safeDo(_ACTION_START, func() error {
+		sv.resetError()
+		sv.watchState(sv.Wait)
+		if err := sv.s.Start(); err != nil {
+			sv.doneError(err)
+			return err
+		}
+		sv.setRunning(true)
+		sv.quitC = make(chan bool)
+		sv.loopDone = make(chan bool)
+		go sv.supervise()
+		return nil
+	})
+}
+
+func (sv *Supervisor) Stop() error {
+	return sv.safeDo(_ACTION_STOP, func() error {
+		close(sv.quitC)
+		<-sv.loopDone
+		var err error
+		if sv.isRunning() {
+			err = sv.s.Stop()
+			sv.setRunning(false)
+		}
+		sv.doneNilError()
+		return err
+	})
+}
+
+// Subscribe restart events, slow receivers lose events
+func (sv *Supervisor) Subscribe() chan RestartEvent {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	C := make(chan RestartEvent, 10)
+	sv.subs[C] = true
+	return C
+}
+
+// unsubscribe will also close channel
+func (sv *Supervisor) Unsubscribe(C chan RestartEvent) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	if sv.subs[C] {
+		delete(sv.subs, C)
+		close(C)
+	}
+}
+
+func (sv *Supervisor) pub(e RestartEvent) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	for C := range sv.subs {
+		select {
+		case C <- e:
+		default:
+		}
+	}
+}
+
+func (sv *Supervisor) setRunning(v bool) {
+	sv.mu.Lock()
+	sv.running = v
+	sv.mu.Unlock()
+}
+
+func (sv *Supervisor) isRunning() bool {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return sv.running
+}
+
+func (sv *Supervisor) supervise() {
+	defer close(sv.loopDone)
+	attempt := 0
+	for {
+		startAt := time.Now()
+		waitC := make(chan error, 1)
+		go func() { waitC <- sv.s.Wait() }()
+		var err error
+		select {
+		case err = <-waitC:
+		case <-sv.quitC:
+			return
+		}
+		if err == nil {
+			err = errors.New("servicer quit unexpectedly")
+		}
+		if sv.policy.ResetAfter > 0 && time.Since(startAt) >= sv.policy.ResetAfter {
+			attempt = 0
+		}
+		// a quit servicer still need Stop before it can be started again
+		sv.s.Stop()
+		sv.setRunning(false)
+		for {
+			attempt++
+			if sv.policy.MaxRestarts >= 0 && attempt > sv.policy.MaxRestarts {
+				sv.pub(RestartEvent{Time: time.Now(), Attempt: attempt, Err: err, GaveUp: true})
+				sv.doneError(errors.Wrapf(err, "gave up after %d restarts", attempt-1))
+				return
+			}
+			delay := sv.policy.delay(attempt)
+			sv.pub(RestartEvent{Time: time.Now(), Attempt: attempt, Err: err, Delay: delay})
+			sv.restarting(err)
+			select {
+			case <-time.After(delay):
+			case <-sv.quitC:
+				return
+			}
+			if err = sv.s.Start(); err == nil {
+				sv.setRunning(true)
+				sv.recovered()
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package stf
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServicer quit with error when fail is called, Start fail while startErr is set
+type flakyServicer struct {
+	mu       sync.Mutex
+	starts   int
+	startErr error
+	errC     chan error
+}
+
+func (f *flakyServicer) Start() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.starts++
+	if f.startErr != nil {
+		return f.startErr
+	}
+	f.errC = make(chan error, 1)
+	return nil
+}
+
+func (f *flakyServicer) Stop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case f.errC <- nil:
+	default:
+	}
+	return nil
+}
+
+func (f *flakyServicer) Wait() error {
+	f.mu.Lock()
+	errC := f.errC
+	f.mu.Unlock()
+	err := <-errC
+	errC <- err // let other waiters return too
+	return err
+}
+
+func (f *flakyServicer) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errC <- err
+}
+
+func (f *flakyServicer) startCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.starts
+}
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, p.delay(1))
+	assert.Equal(t, 4*time.Second, p.delay(3))
+	assert.Equal(t, 5*time.Second, p.delay(10))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.delay(1)
+		assert.True(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, d)
+	}
+
+	// doubling must not overflow
+	p = Policy{Backoff: time.Second}
+	assert.Equal(t, maxRestartDelay, p.delay(100))
+	assert.Equal(t, maxRestartDelay, p.delay(1<<30))
+	p.Backoff = time.Duration(1 << 62)
+	assert.Equal(t, maxRestartDelay, p.delay(2))
+	p.MaxBackoff = time.Duration(math.MaxInt64)
+	assert.Equal(t, p.MaxBackoff, p.delay(3))
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	f := &flakyServicer{}
+	sv := Supervise(f, Policy{MaxRestarts: 2, Backoff: time.Millisecond})
+	eventC := sv.Subscribe()
+	assert.NoError(t, sv.Start())
+
+	f.fail(errors.New("usb disconnected"))
+	e := <-eventC
+	assert.Equal(t, 1, e.Attempt)
+	assert.False(t, e.GaveUp)
+	assert.EqualError(t, e.Err, "usb disconnected")
+	for f.startCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	f.mu.Lock()
+	f.startErr = errors.New("device offline")
+	f.mu.Unlock()
+	f.fail(errors.New("usb disconnected"))
+	assert.Equal(t, 2, (<-eventC).Attempt)
+	e = <-eventC
+	assert.True(t, e.GaveUp)
+	assert.EqualError(t, e.Err, "device offline")
+
+	err := sv.Wait()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "device offline")
+	assert.NoError(t, sv.Stop())
+}
+
+func TestSupervisorStop(t *testing.T) {
+	f := &flakyServicer{}
+	sv := Supervise(f, DefaultPolicy)
+	assert.NoError(t, sv.Start())
+	assert.NoError(t, sv.Stop())
+	assert.NoError(t, sv.Wait())
+	assert.Equal(t, 1, f.startCount())
+	assert.Equal(t, ErrServiceNotStarted, sv.Stop())
+}
+
+func TestSupervisorFailedStart(t *testing.T) {
+	f := &flakyServicer{startErr: errors.New("no device")}
+	sv := Supervise(f, Policy{MaxRestarts: 1, Backoff: time.Millisecond})
+	assert.EqualError(t, sv.Start(), "no device")
+	assert.EqualError(t, sv.Wait(), "no device", "Wait must not block or panic after a failed start")
+}
@@ -0,0 +1,108 @@
+package stf
+
+import (
+	"time"
+)
+
+type scriptOpKind int
+
+const (
+	_SCRIPT_EVENT = scriptOpKind(iota)
+	_SCRIPT_COMMIT
+	_SCRIPT_WAIT
+)
+
+type scriptOp struct {
+	kind  scriptOpKind
+	event TouchEvent
+	wait  time.Duration
+}
+
+// TouchScript is a sequence of touch events, commits and waits.
+// STFTouch send the whole script to minitouch at once and waits are done by device,
+// so the timing is not affected by host scheduling.
+//
+//	script := NewTouchScript().
+//		Down(0, 0.5, 0.8).Commit().Wait(50 * time.Millisecond).
+//		Move(0, 0.5, 0.2).Commit().Wait(50 * time.Millisecond).
+//		Up(0).Commit()
+type TouchScript struct {
+	ops []scriptOp
+}
+
+// ScriptRunner is implemented by Toucher which can run TouchScript on device
+type ScriptRunner interface {
+	RunScript(script *TouchScript) error
+}
+
+func NewTouchScript() *TouchScript {
+	return &TouchScript{}
+}
+
+// Event add any event, eg: down with custom pressure
+func (s *TouchScript) Event(e TouchEvent) *TouchScript {
+	s.ops = append(s.ops, scriptOp{kind: _SCRIPT_EVENT, event: e})
+	return s
+}
+
+func (s *TouchScript) Down(index int, xP, yP float64) *TouchScript {
+	return s.Event(TouchEvent{Action: TOUCH_DOWN, Index: index, X: xP, Y: yP})
+}
+
+func (s *TouchScript) Move(index int, xP, yP float64) *TouchScript {
+	return s.Event(TouchEvent{Action: TOUCH_MOVE, Index: index, X: xP, Y: yP})
+}
+
+func (s *TouchScript) Up(index int) *TouchScript {
+	return s.Event(TouchEvent{Action: TOUCH_UP, Index: index})
+}
+
+// Commit make all events since last commit take effect together
+func (s *TouchScript) Commit() *TouchScript {
+	s.ops = append(s.ops, scriptOp{kind: _SCRIPT_COMMIT})
+	return s
+}
+
+// Wait is in millisecond precision
+func (s *TouchScript) Wait(d time.Duration) *TouchScript {
+	if d >= time.Millisecond {
+		s.ops = append(s.ops, scriptOp{kind: _SCRIPT_WAIT, wait: d.Truncate(time.Millisecond)})
+	}
+	return s
+}
+
+// Duration is the sum of all waits
+func (s *TouchScript) Duration() time.Duration {
+	var total time.Duration
+	for _, op := range s.ops {
+		if op.kind == _SCRIPT_WAIT {
+			total += op.wait
+		}
+	}
+	return total
+}
+
+// replay run script on host for Toucher which is not a ScriptRunner
+func (s *TouchScript) replay(t Toucher, sleep func(time.Duration)) error {
+	var events []TouchEvent
+	for _, op := range s.ops {
+		switch op.kind {
+		case _SCRIPT_EVENT:
+			events = append(events, op.event)
+		case _SCRIPT_COMMIT:
+			if len(events) == 0 {
+				continue
+			}
+			if err := t.Do(events...); err != nil {
+				return err
+			}
+			events = events[:0]
+		case _SCRIPT_WAIT:
+			sleep(op.wait)
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return t.Do(events...)
+}
@@ -0,0 +1,30 @@
+package stf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTouchScript(t *testing.T) {
+	script := NewTouchScript().
+		Down(0, 0.5, 0.5).Down(1, 0.1, 0.1).Commit().
+		Wait(50*time.Millisecond).Wait(time.Microsecond).
+		Move(0, 0.5, 0.25).Commit().
+		Wait(1500 * time.Microsecond).
+		Up(0).Up(1).Commit()
+	assert.Equal(t, 51*time.Millisecond, script.Duration())
+
+	touch := &STFTouch{info: TouchInfo{MaxX: 1000, MaxY: 2000, MaxPressure: 100}}
+	cmds, err := touch.scriptCommands(script)
+	assert.NoError(t, err)
+	assert.Equal(t, "d 0 500 1000 50\nd 1 100 200 50\nc\nw 50\nm 0 500 500 50\nc\nw 1\nu 0\nu 1\nc", cmds)
+
+	fake := &fakeToucher{}
+	var slept time.Duration
+	assert.NoError(t, script.replay(fake, func(d time.Duration) { slept += d }))
+	assert.Len(t, fake.commits, 3)
+	assert.Len(t, fake.commits[0], 2)
+	assert.Equal(t, 51*time.Millisecond, slept)
+}
@@ -1,10 +1,9 @@
 package stf
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -12,29 +11,66 @@ import (
 	"time"
 
 	adb "github.com/openatx/go-adb"
+	"github.com/pkg/errors"
 )
 
+// PushFileFromHTTP download urlStr and write it to device
 func PushFileFromHTTP(d *adb.Device, dst string, perms os.FileMode, urlStr string) error {
-	wc, err := d.OpenWrite(dst, perms, time.Now())
-	if err != nil {
-		return err
-	}
+	var lm logMixin
+	lm.initLogger(d, "push")
+	lm.log().Info("download", "url", urlStr, "dst", dst)
 	resp, err := http.Get(urlStr)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("http download <%s> status %v", urlStr, resp.Status)
 	}
-	defer resp.Body.Close()
-	log.Printf("downloading to %s ...", dst)
-	if _, err = io.Copy(wc, resp.Body); err != nil {
+	return PushFile(d, dst, perms, resp.Body)
+}
+
+// PushFile write content of rd to device
+func PushFile(d *adb.Device, dst string, perms os.FileMode, rd io.Reader) error {
+	wc, err := d.OpenWrite(dst, perms, time.Now())
+	if err != nil {
 		return err
 	}
-	if err := wc.Close(); err != nil {
+	if _, err = io.Copy(wc, rd); err != nil {
+		wc.Close()
 		return err
 	}
-	return nil
+	return wc.Close()
+}
+
+// ctxReader stop reading once ctx is done, so copying in progress can be aborted
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// deviceABIAndSDK return ro.product.cpu.abi and ro.build.version.sdk
+func deviceABIAndSDK(d *adb.Device) (abi, sdk string, err error) {
+	props, err := d.Properties()
+	if err != nil {
+		return
+	}
+	abi, ok := props["ro.product.cpu.abi"]
+	if !ok {
+		return "", "", errors.New("No ro.product.cpu.abi propery")
+	}
+	sdk, ok = props["ro.build.version.sdk"]
+	if !ok {
+		return "", "", errors.New("No ro.build.version.sdk propery")
+	}
+	return abi, sdk, nil
 }
 
 func AdbCheckOutput(d *adb.Device, name string, args ...string) (outStr string, err error) {